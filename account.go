@@ -0,0 +1,495 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/dghubble/oauth1"
+	"github.com/pyzh/fanfou-telegram-bot/fanfou"
+	tb "gopkg.in/tucnak/telebot.v2"
+)
+
+// userDataKinds are the record kinds holding per-user data, keyed by
+// userKey. /deletemydata purges all of them.
+var userDataKinds = []struct {
+	kind  string
+	label string
+}{
+	{settingsKind, "settings"},
+	{subscriptionKind, "home timeline subscription"},
+	{mentionCursorKind, "mention notification settings"},
+	{statusMessageKind, "message to status links"},
+	{dmCursorKind, "direct message forwarding settings"},
+	{dmMessageKind, "forwarded direct messages"},
+	{postedMessageKind, "message to posted status links"},
+	{splitDraftKind, "long messages waiting to be split"},
+	{lastLocationKind, "saved location"},
+	{forwardDraftKind, "forwards waiting for a choice"},
+}
+
+// userKey builds a record key owned by telegramID.
+func userKey(telegramID int, parts ...string) string {
+	return strings.Join(append([]string{strconv.Itoa(telegramID)}, parts...), ":")
+}
+
+var (
+	logoutConfirmBtn     = tb.InlineButton{Unique: "logout_confirm", Text: "Yes, log out"}
+	deleteDataConfirmBtn = tb.InlineButton{Unique: "deletemydata_confirm", Text: "Yes, delete everything"}
+	cancelBtn            = tb.InlineButton{Unique: "cancel", Text: "Cancel"}
+
+	switchAccountBtn = tb.InlineButton{Unique: "account_switch"}
+	removeAccountBtn = tb.InlineButton{Unique: "account_remove"}
+	fanoutAccountBtn = tb.InlineButton{Unique: "account_fanout"}
+)
+
+// clientFor returns a Fanfou API client authorized as info.
+func clientFor(info *oauthInfo) *fanfou.Client {
+	token := oauth1.NewToken(info.Token, info.Secret)
+	client := fanfou.NewClient(&oauthConfig, token)
+	client.BaseURL = config.FanfouAPIURL
+	return client
+}
+
+// newFanfouClient returns a Fanfou API client authorized as the active
+// Fanfou account of telegramID.
+func newFanfouClient(ctx context.Context, telegramID int) (*fanfou.Client, error) {
+	info, err := activeAccount(ctx, telegramID)
+	if err != nil {
+		return nil, err
+	}
+	return clientFor(info), nil
+}
+
+// linkAccount saves a freshly authorized token and makes it the active
+// account.
+func linkAccount(ctx context.Context, telegramID int, token, secret string) (*oauthInfo, error) {
+	info := &oauthInfo{Token: token, Secret: secret}
+	user, err := clientFor(info).VerifyCredentials()
+	if err != nil {
+		return nil, err
+	}
+	info.FanfouID, info.ScreenName = user.ID, user.ScreenName
+	if err := tokenStore.Put(ctx, telegramID, info); err != nil {
+		return nil, err
+	}
+	watchAccount(ctx, telegramID, info.FanfouID)
+	return info, updateSettings(ctx, telegramID, func(s *userSettings) {
+		s.ActiveAccount = info.FanfouID
+	})
+}
+
+// watchAccount turns on mention and direct message forwarding for a
+// linked account.
+func watchAccount(ctx context.Context, telegramID int, fanfouID string) {
+	if err := watchCursor(ctx, mentionCursorKind, telegramID, fanfouID); err != nil {
+		log.Println("watch mentions error ", err)
+	}
+	if err := watchCursor(ctx, dmCursorKind, telegramID, fanfouID); err != nil {
+		log.Println("watch direct messages error ", err)
+	}
+}
+
+// accounts lists the linked accounts of telegramID.
+func accounts(ctx context.Context, telegramID int) ([]*oauthInfo, error) {
+	infos, err := tokenStore.List(ctx, telegramID)
+	if err != nil {
+		return nil, err
+	}
+	for i, info := range infos {
+		if infos[i], err = migrateLegacyAccount(ctx, telegramID, info); err != nil {
+			return nil, err
+		}
+	}
+	return infos, nil
+}
+
+// migrateLegacyAccount moves a token saved without its Fanfou user ID to
+// the per-account layout.
+func migrateLegacyAccount(ctx context.Context, telegramID int, info *oauthInfo) (*oauthInfo, error) {
+	if info.FanfouID != "" {
+		return info, nil
+	}
+	user, err := clientFor(info).VerifyCredentials()
+	if err != nil {
+		return nil, err
+	}
+	migrated := *info
+	migrated.FanfouID, migrated.ScreenName = user.ID, user.ScreenName
+	if err := tokenStore.Put(ctx, telegramID, &migrated); err != nil {
+		return nil, err
+	}
+	if err := tokenStore.Delete(ctx, telegramID, ""); err != nil {
+		return nil, err
+	}
+	watchAccount(ctx, telegramID, migrated.FanfouID)
+	return &migrated, nil
+}
+
+// activeAccount returns the account posts go to, falling back to the
+// first linked one.
+func activeAccount(ctx context.Context, telegramID int) (*oauthInfo, error) {
+	settings, err := getSettings(ctx, telegramID)
+	if err != nil {
+		return nil, err
+	}
+	if settings.ActiveAccount != "" {
+		info, err := tokenStore.Get(ctx, telegramID, settings.ActiveAccount)
+		if err != errNoToken {
+			return info, err
+		}
+	}
+	infos, err := accounts(ctx, telegramID)
+	if err != nil {
+		return nil, err
+	}
+	if len(infos) == 0 {
+		return nil, errNoToken
+	}
+	return infos[0], nil
+}
+
+// postingAccounts returns the accounts a new post goes to: the fan-out
+// selection if there is one, the active account otherwise.
+func postingAccounts(ctx context.Context, telegramID int) ([]*oauthInfo, error) {
+	settings, err := getSettings(ctx, telegramID)
+	if err != nil {
+		return nil, err
+	}
+	var infos []*oauthInfo
+	for _, id := range settings.FanoutAccounts {
+		info, err := tokenStore.Get(ctx, telegramID, id)
+		if err == errNoToken {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, info)
+	}
+	if len(infos) > 0 {
+		return infos, nil
+	}
+	info, err := activeAccount(ctx, telegramID)
+	if err != nil {
+		return nil, err
+	}
+	return []*oauthInfo{info}, nil
+}
+
+func handleAccounts(m *tb.Message) {
+	text, markup, err := accountsView(context.Background(), m.Sender.ID)
+	if err != nil {
+		log.Println("list accounts error ", err)
+		return
+	}
+	bot.Send(m.Sender, text, markup)
+}
+
+// accountsView renders the /accounts message: one row per account with
+// buttons to switch to it, toggle fan-out and remove it.
+func accountsView(ctx context.Context, telegramID int) (string, *tb.ReplyMarkup, error) {
+	infos, err := accounts(ctx, telegramID)
+	if err != nil {
+		return "", nil, err
+	}
+	if len(infos) == 0 {
+		return "No Fanfou account is linked. Send /start to link one.", &tb.ReplyMarkup{}, nil
+	}
+	active, err := activeAccount(ctx, telegramID)
+	if err != nil {
+		return "", nil, err
+	}
+	settings, err := getSettings(ctx, telegramID)
+	if err != nil {
+		return "", nil, err
+	}
+
+	var rows [][]tb.InlineButton
+	for _, info := range infos {
+		name := info.ScreenName + " (" + info.FanfouID + ")"
+		if info.FanfouID == active.FanfouID {
+			name = "✅ " + name
+		}
+		fanout := "Fan-out: off"
+		if containsString(settings.FanoutAccounts, info.FanfouID) {
+			fanout = "Fan-out: on"
+		}
+		sw, fo, rm := switchAccountBtn, fanoutAccountBtn, removeAccountBtn
+		sw.Text, sw.Data = name, info.FanfouID
+		fo.Text, fo.Data = fanout, info.FanfouID
+		rm.Text, rm.Data = "Remove", info.FanfouID
+		rows = append(rows, []tb.InlineButton{sw}, []tb.InlineButton{fo, rm})
+	}
+	text := "Your Fanfou accounts. Tap one to make it active. " +
+		"When fan-out is on for any account, every post goes to all of those accounts. " +
+		"Send /start to link another one."
+	return text, &tb.ReplyMarkup{InlineKeyboard: rows}, nil
+}
+
+func handleSwitchAccount(c *tb.Callback) {
+	ctx := context.Background()
+	if _, err := tokenStore.Get(ctx, c.Sender.ID, c.Data); err != nil {
+		bot.Respond(c, &tb.CallbackResponse{Text: "This account is no longer linked."})
+		refreshAccountsView(ctx, c)
+		return
+	}
+	err := updateSettings(ctx, c.Sender.ID, func(s *userSettings) { s.ActiveAccount = c.Data })
+	if err != nil {
+		log.Println("switch account error ", err)
+		bot.Respond(c, &tb.CallbackResponse{Text: "Failed, please try again."})
+		return
+	}
+	bot.Respond(c, &tb.CallbackResponse{Text: "Now posting as " + c.Data})
+	refreshAccountsView(ctx, c)
+}
+
+func handleFanoutAccount(c *tb.Callback) {
+	ctx := context.Background()
+	err := updateSettings(ctx, c.Sender.ID, func(s *userSettings) {
+		if containsString(s.FanoutAccounts, c.Data) {
+			s.FanoutAccounts = removeString(s.FanoutAccounts, c.Data)
+		} else {
+			s.FanoutAccounts = append(s.FanoutAccounts, c.Data)
+		}
+	})
+	if err != nil {
+		log.Println("toggle fan-out error ", err)
+		bot.Respond(c, &tb.CallbackResponse{Text: "Failed, please try again."})
+		return
+	}
+	bot.Respond(c)
+	refreshAccountsView(ctx, c)
+}
+
+func handleRemoveAccount(c *tb.Callback) {
+	ctx := context.Background()
+	if err := unlinkAccount(ctx, c.Sender.ID, c.Data); err != nil {
+		log.Println("remove account error ", err)
+		bot.Respond(c, &tb.CallbackResponse{Text: "Failed, please try again."})
+		return
+	}
+	bot.Respond(c, &tb.CallbackResponse{Text: "Removed " + c.Data})
+	refreshAccountsView(ctx, c)
+}
+
+func refreshAccountsView(ctx context.Context, c *tb.Callback) {
+	text, markup, err := accountsView(ctx, c.Sender.ID)
+	if err != nil {
+		log.Println("list accounts error ", err)
+		return
+	}
+	bot.Edit(c.Message, text, markup)
+}
+
+// unlinkAccount deletes one account and drops it from the settings.
+func unlinkAccount(ctx context.Context, telegramID int, fanfouID string) error {
+	if err := tokenStore.Delete(ctx, telegramID, fanfouID); err != nil {
+		return err
+	}
+	for _, kind := range []string{mentionCursorKind, dmCursorKind} {
+		if err := recordStore.DeleteRecord(ctx, kind, userKey(telegramID, fanfouID)); err != nil {
+			return err
+		}
+	}
+	return updateSettings(ctx, telegramID, func(s *userSettings) {
+		if s.ActiveAccount == fanfouID {
+			s.ActiveAccount = ""
+		}
+		s.FanoutAccounts = removeString(s.FanoutAccounts, fanfouID)
+	})
+}
+
+// unlinkAllAccounts deletes every token of telegramID, including ones
+// that fail to decrypt, and returns how many there were.
+func unlinkAllAccounts(ctx context.Context, telegramID int) (int, error) {
+	var ids []string
+	infos, err := tokenStore.List(ctx, telegramID)
+	if err == nil {
+		for _, info := range infos {
+			ids = append(ids, info.FanfouID)
+		}
+	} else if store, ok := tokenStore.(*encryptedTokenStore); ok {
+		if infos, err = store.TokenStore.List(ctx, telegramID); err != nil {
+			return 0, err
+		}
+		for _, info := range infos {
+			ids = append(ids, info.FanfouID)
+		}
+	} else {
+		return 0, err
+	}
+	for _, id := range ids {
+		if err := unlinkAccount(ctx, telegramID, id); err != nil {
+			return 0, err
+		}
+	}
+	return len(ids), nil
+}
+
+func handleLogout(m *tb.Message) {
+	infos, err := tokenStore.List(context.Background(), m.Sender.ID)
+	if err == nil && len(infos) == 0 {
+		bot.Send(m.Sender, "No Fanfou account is linked. Send /start to link one.")
+		return
+	}
+	bot.Send(m.Sender, "Unlink all your Fanfou accounts from this bot? To remove only one, use /accounts.", &tb.ReplyMarkup{
+		InlineKeyboard: [][]tb.InlineButton{{logoutConfirmBtn, cancelBtn}},
+	})
+}
+
+func handleLogoutConfirm(c *tb.Callback) {
+	if _, err := unlinkAllAccounts(context.Background(), c.Sender.ID); err != nil {
+		log.Println("delete token error ", err)
+		bot.Respond(c, &tb.CallbackResponse{Text: "Failed, please try again."})
+		return
+	}
+	bot.Respond(c)
+	bot.Edit(c.Message, "Logged out. Send /start to link a Fanfou account again.")
+}
+
+func handleDeleteMyData(m *tb.Message) {
+	bot.Send(m.Sender, "Delete everything this bot stores about you, including your Fanfou authorization? This can't be undone.", &tb.ReplyMarkup{
+		InlineKeyboard: [][]tb.InlineButton{{deleteDataConfirmBtn, cancelBtn}},
+	})
+}
+
+func handleDeleteMyDataConfirm(c *tb.Callback) {
+	removed, err := purgeUserData(context.Background(), c.Sender.ID)
+	if err != nil {
+		log.Println("purge user data error ", err)
+		bot.Respond(c, &tb.CallbackResponse{Text: "Failed, please try again."})
+		return
+	}
+	bot.Respond(c)
+	if len(removed) == 0 {
+		bot.Edit(c.Message, "There was nothing stored about you.")
+		return
+	}
+	bot.Edit(c.Message, "Deleted:\n- "+strings.Join(removed, "\n- "))
+}
+
+func handleCancel(c *tb.Callback) {
+	bot.Respond(c)
+	bot.Edit(c.Message, "Cancelled.")
+}
+
+// purgeUserData deletes everything stored for telegramID and describes
+// what was removed.
+func purgeUserData(ctx context.Context, telegramID int) ([]string, error) {
+	var removed []string
+
+	n, err := unlinkAllAccounts(ctx, telegramID)
+	if err != nil {
+		return removed, err
+	}
+	if n > 0 {
+		removed = append(removed, fmt.Sprintf("Fanfou access tokens (%d)", n))
+	}
+
+	n, err = purgeAuthStates(ctx, telegramID)
+	if err != nil {
+		return removed, err
+	}
+	if n > 0 {
+		removed = append(removed, fmt.Sprintf("pending authorization links (%d)", n))
+	}
+
+	n, err = purgeChannelMirrors(ctx, telegramID)
+	if err != nil {
+		return removed, err
+	}
+	if n > 0 {
+		removed = append(removed, fmt.Sprintf("channel mirrors (%d)", n))
+	}
+
+	n, err = purgeTeamGroups(ctx, telegramID)
+	if err != nil {
+		return removed, err
+	}
+	if n > 0 {
+		removed = append(removed, fmt.Sprintf("shared group accounts (%d)", n))
+	}
+
+	drafts, entries, err := purgeTeamContributions(ctx, telegramID)
+	if err != nil {
+		return removed, err
+	}
+	if drafts > 0 {
+		removed = append(removed, fmt.Sprintf("drafts proposed in groups (%d)", drafts))
+	}
+	if entries > 0 {
+		removed = append(removed, fmt.Sprintf("your name in group audit logs (%d entries)", entries))
+	}
+
+	for _, k := range userDataKinds {
+		n, err := purgeRecords(ctx, k.kind, telegramID)
+		if err != nil {
+			return removed, err
+		}
+		if n > 0 {
+			removed = append(removed, fmt.Sprintf("%s (%d)", k.label, n))
+		}
+	}
+	return removed, nil
+}
+
+// purgeRecords deletes the records of kind owned by telegramID.
+func purgeRecords(ctx context.Context, kind string, telegramID int) (int, error) {
+	id := strconv.Itoa(telegramID)
+	keys, err := recordStore.RecordKeys(ctx, kind, id)
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, key := range keys {
+		if key != id && !strings.HasPrefix(key, id+":") {
+			continue
+		}
+		if err := recordStore.DeleteRecord(ctx, kind, key); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+func purgeAuthStates(ctx context.Context, telegramID int) (int, error) {
+	keys, err := recordStore.RecordKeys(ctx, authStateKind, "")
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, key := range keys {
+		pending := &authState{}
+		if err := recordStore.GetRecord(ctx, authStateKind, key, pending); err != nil || pending.TelegramID != telegramID {
+			continue
+		}
+		if err := recordStore.DeleteRecord(ctx, authStateKind, key); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func removeString(list []string, s string) []string {
+	var out []string
+	for _, v := range list {
+		if v != s {
+			out = append(out, v)
+		}
+	}
+	return out
+}
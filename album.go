@@ -0,0 +1,244 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"log"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pyzh/fanfou-telegram-bot/fanfou"
+	tb "gopkg.in/tucnak/telebot.v2"
+)
+
+// albumWindow is how long to wait for the rest of an album after one of
+// its photos arrives. Telegram sends the parts of an album as separate
+// updates, usually within a second.
+const albumWindow = 2 * time.Second
+
+// collageWidth is the width of collages in pixels.
+const collageWidth = 1200
+
+type album struct {
+	sender *tb.User
+	photos []*tb.Message
+	timer  *time.Timer
+}
+
+var (
+	albumsMu sync.Mutex
+	albums   = make(map[string]*album)
+)
+
+// collectAlbum adds m to its album and posts the album once no more
+// photos arrive within albumWindow.
+func collectAlbum(m *tb.Message) {
+	albumsMu.Lock()
+	defer albumsMu.Unlock()
+	a, ok := albums[m.AlbumID]
+	if !ok {
+		a = &album{sender: m.Sender}
+		albums[m.AlbumID] = a
+		id := m.AlbumID
+		a.timer = time.AfterFunc(albumWindow, func() {
+			albumsMu.Lock()
+			delete(albums, id)
+			albumsMu.Unlock()
+			postAlbum(a)
+		})
+	} else {
+		a.timer.Reset(albumWindow)
+	}
+	a.photos = append(a.photos, m)
+}
+
+// postAlbum posts a finished album as a collage or as a series of
+// photos, as the sender chose with /albums.
+func postAlbum(a *album) {
+	ctx := context.Background()
+	sort.Slice(a.photos, func(i, j int) bool { return a.photos[i].ID < a.photos[j].ID })
+	caption := fmt.Sprintf("Just posted %d photos", len(a.photos))
+	source := a.photos[0]
+	for _, m := range a.photos {
+		if m.Caption != "" {
+			caption, source = m.Caption, m
+			break
+		}
+	}
+	// Forwarded albums are credited like single photos.
+	if isForward(source) {
+		if text, ok := forwardText(ctx, source, caption); ok {
+			caption = text
+		} else {
+			caption = attributeForward(source, caption)
+		}
+	}
+
+	settings, err := getSettings(ctx, a.sender.ID)
+	if err != nil {
+		log.Println("get settings error ", err)
+		return
+	}
+	photos := make([][]byte, len(a.photos))
+	var notes []string
+	for i, m := range a.photos {
+		data, filePath, err := downloadFile(m.Photo.FileID)
+		if err != nil {
+			log.Println("get file error ", err)
+			bot.Send(a.sender, "Could not download the album from Telegram, please try again.")
+			return
+		}
+		var changes []string
+		if photos[i], _, changes, err = preparePhoto(data, filePath); err != nil {
+			log.Println("prepare photo error ", err)
+			bot.Send(a.sender, "Sorry, a photo of this album could not be read.")
+			return
+		}
+		for _, note := range changes {
+			// Sizes differ per photo; keep only what was done.
+			if i := strings.Index(note, " from"); i > 0 {
+				note = note[:i]
+			}
+			if !containsString(notes, note) {
+				notes = append(notes, note)
+			}
+		}
+	}
+	if settings.AlbumCollage {
+		collage, err := makeCollage(photos)
+		if err != nil {
+			log.Println("make collage error ", err)
+			bot.Send(a.sender, "Could not make a collage of these photos.")
+			return
+		}
+		photos = [][]byte{collage}
+	}
+
+	infos, err := postingAccounts(ctx, a.sender.ID)
+	if err != nil {
+		log.Println("get key error ", err)
+		return
+	}
+	location := takeLocation(ctx, a.sender.ID)
+	for _, info := range infos {
+		if err := postPhotoSeries(ctx, a.sender, info, caption, location, photos, notes); err != nil {
+			log.Println("send photo error ", err)
+			sendFanfouError(bot, a.sender, err)
+		}
+	}
+}
+
+// postPhotoSeries uploads photos in order, the first with caption and
+// each of the others numbered and replying to the one before. Notes go
+// in the receipt.
+func postPhotoSeries(ctx context.Context, to *tb.User, info *oauthInfo, caption, location string, photos [][]byte, notes []string) error {
+	params := &fanfou.StatusParams{Status: caption, Location: location}
+	for i, photo := range photos {
+		if i > 0 {
+			params.Status = fmt.Sprintf("(%d/%d)", i+1, len(photos))
+		}
+		status, err := clientFor(info).UploadPhoto(params, fmt.Sprintf("photo%d.jpg", i+1), bytes.NewReader(photo))
+		if err != nil {
+			return err
+		}
+		if i == 0 {
+			sendReceipt(ctx, to.ID, info, status, notes...)
+		}
+		params.InReplyToStatusID = status.ID
+		params.InReplyToUserID = info.FanfouID
+	}
+	return nil
+}
+
+// makeCollage lays photos out in a grid of square cells, each cropped to
+// its center, and encodes the result as JPEG.
+func makeCollage(photos [][]byte) ([]byte, error) {
+	cols := int(math.Ceil(math.Sqrt(float64(len(photos)))))
+	rows := (len(photos) + cols - 1) / cols
+	cell := collageWidth / cols
+	dst := image.NewRGBA(image.Rect(0, 0, cell*cols, cell*rows))
+	draw.Draw(dst, dst.Bounds(), image.White, image.Point{}, draw.Src)
+	for i, data := range photos {
+		src, _, err := decodeImage(data)
+		if err != nil {
+			return nil, err
+		}
+		x, y := i%cols*cell, i/cols*cell
+		scaleImage(dst, image.Rect(x, y, x+cell, y+cell), src, centerSquare(src.Bounds()))
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, dst, &jpeg.Options{Quality: 90}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func centerSquare(r image.Rectangle) image.Rectangle {
+	if r.Dx() > r.Dy() {
+		x := r.Min.X + (r.Dx()-r.Dy())/2
+		return image.Rect(x, r.Min.Y, x+r.Dy(), r.Max.Y)
+	}
+	y := r.Min.Y + (r.Dy()-r.Dx())/2
+	return image.Rect(r.Min.X, y, r.Max.X, y+r.Dx())
+}
+
+// scaleImage draws the sr part of src into the dr part of dst, averaging
+// the source pixels that fall in each destination pixel.
+func scaleImage(dst draw.Image, dr image.Rectangle, src image.Image, sr image.Rectangle) {
+	sx := float64(sr.Dx()) / float64(dr.Dx())
+	sy := float64(sr.Dy()) / float64(dr.Dy())
+	for y := 0; y < dr.Dy(); y++ {
+		y0 := sr.Min.Y + int(float64(y)*sy)
+		y1 := sr.Min.Y + int(float64(y+1)*sy)
+		if y1 <= y0 {
+			y1 = y0 + 1
+		}
+		for x := 0; x < dr.Dx(); x++ {
+			x0 := sr.Min.X + int(float64(x)*sx)
+			x1 := sr.Min.X + int(float64(x+1)*sx)
+			if x1 <= x0 {
+				x1 = x0 + 1
+			}
+			var r, g, b, a, n uint64
+			for py := y0; py < y1; py++ {
+				for px := x0; px < x1; px++ {
+					pr, pg, pb, pa := src.At(px, py).RGBA()
+					r, g, b, a, n = r+uint64(pr), g+uint64(pg), b+uint64(pb), a+uint64(pa), n+1
+				}
+			}
+			dst.Set(dr.Min.X+x, dr.Min.Y+y, color.RGBA64{uint16(r / n), uint16(g / n), uint16(b / n), uint16(a / n)})
+		}
+	}
+}
+
+func handleAlbums(m *tb.Message) {
+	var collage bool
+	switch strings.TrimSpace(m.Payload) {
+	case "series":
+	case "collage":
+		collage = true
+	default:
+		bot.Send(m.Sender, "Usage: /albums series|collage. Albums are posted as a series of photos replying to each other, or as one collage.")
+		return
+	}
+	err := updateSettings(context.Background(), m.Sender.ID, func(s *userSettings) {
+		s.AlbumCollage = collage
+	})
+	if err != nil {
+		log.Println("save settings error ", err)
+		return
+	}
+	if collage {
+		bot.Send(m.Sender, "Albums will be posted as one collage.")
+	} else {
+		bot.Send(m.Sender, "Albums will be posted as a series of photos.")
+	}
+}
@@ -0,0 +1,375 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/pyzh/fanfou-telegram-bot/fanfou"
+	tb "gopkg.in/tucnak/telebot.v2"
+)
+
+const (
+	channelMirrorKind = "channel_mirrors"
+	channelPostKind   = "channel_posts"
+)
+
+var hashtagPattern = regexp.MustCompile(`#[^\s#]+`)
+
+// channelMirror links a Telegram channel to a Fanfou account, keyed by
+// the channel's chat ID. Posts go out with the token TelegramID linked
+// for Account.
+type channelMirror struct {
+	ChannelID  int64
+	Title      string
+	Username   string
+	TelegramID int
+	Account    string
+	Disabled   bool
+	// Include, when not empty, mirrors only posts with one of these
+	// hashtags; posts with one of Exclude are never mirrored.
+	Include  []string
+	Exclude  []string
+	LinkBack bool
+}
+
+func chatKey(chatID int64) string {
+	return strconv.FormatInt(chatID, 10)
+}
+
+func (c *channelMirror) name() string {
+	if c.Username != "" {
+		return "@" + c.Username
+	}
+	return c.Title
+}
+
+// handleChannel manages channel mirrors:
+//
+//	/channel @name link|unlink|on|off
+//	/channel @name include|exclude #tag...
+//	/channel @name footer on|off
+func handleChannel(m *tb.Message) {
+	ctx := context.Background()
+	args := strings.Fields(m.Payload)
+	if len(args) == 0 {
+		listChannels(ctx, m)
+		return
+	}
+	if len(args) < 2 {
+		bot.Send(m.Sender, "Usage: /channel @name link|unlink|on|off, /channel @name include|exclude #tag..., /channel @name footer on|off")
+		return
+	}
+	chat, err := bot.ChatByID(args[0])
+	if err != nil || chat.Type != tb.ChatChannel {
+		bot.Send(m.Sender, args[0]+" is not a channel the bot can see. Add the bot to the channel as an administrator first.")
+		return
+	}
+	member, err := bot.ChatMemberOf(chat, m.Sender)
+	if err != nil || (member.Role != tb.Administrator && member.Role != tb.Creator) {
+		bot.Send(m.Sender, "Only administrators of "+args[0]+" can change its mirror.")
+		return
+	}
+
+	key := chatKey(chat.ID)
+	mirror := &channelMirror{}
+	err = recordStore.GetRecord(ctx, channelMirrorKind, key, mirror)
+	if err != nil && err != errNoRecord {
+		log.Println("get channel mirror error ", err)
+		return
+	}
+	if args[1] == "link" {
+		info, err := activeAccount(ctx, m.Sender.ID)
+		if err != nil {
+			bot.Send(m.Sender, "Link a Fanfou account with /start first.")
+			return
+		}
+		mirror.TelegramID, mirror.Account, mirror.Disabled = m.Sender.ID, info.FanfouID, false
+	} else if err == errNoRecord {
+		bot.Send(m.Sender, args[0]+" is not mirrored, use /channel "+args[0]+" link first.")
+		return
+	}
+	mirror.ChannelID, mirror.Title, mirror.Username = chat.ID, chat.Title, chat.Username
+
+	var reply string
+	switch args[1] {
+	case "link":
+		reply = fmt.Sprintf("Posts in %s will be mirrored to Fanfou as %s.", mirror.name(), mirror.Account)
+	case "unlink":
+		if err := recordStore.DeleteRecord(ctx, channelMirrorKind, key); err != nil {
+			log.Println("delete channel mirror error ", err)
+			return
+		}
+		bot.Send(m.Sender, mirror.name()+" is no longer mirrored.")
+		return
+	case "on", "off":
+		mirror.Disabled = args[1] == "off"
+		reply = "Mirroring of " + mirror.name() + " is " + args[1] + "."
+	case "include", "exclude":
+		var tags []string
+		for _, tag := range args[2:] {
+			tags = append(tags, strings.ToLower(strings.TrimPrefix(tag, "#")))
+		}
+		if args[1] == "include" {
+			mirror.Include = tags
+		} else {
+			mirror.Exclude = tags
+		}
+		reply = mirrorFilters(mirror)
+	case "footer":
+		mirror.LinkBack = len(args) > 2 && args[2] == "on"
+		reply = "Mirrored posts will not link back to Telegram."
+		if mirror.LinkBack {
+			reply = "Mirrored posts will link back to Telegram."
+		}
+	default:
+		bot.Send(m.Sender, "Unknown action "+args[1]+".")
+		return
+	}
+	if err := recordStore.PutRecord(ctx, channelMirrorKind, key, mirror); err != nil {
+		log.Println("save channel mirror error ", err)
+		return
+	}
+	bot.Send(m.Sender, reply)
+}
+
+func mirrorFilters(c *channelMirror) string {
+	text := "All posts are mirrored"
+	if len(c.Include) > 0 {
+		text = "Posts tagged #" + strings.Join(c.Include, ", #") + " are mirrored"
+	}
+	if len(c.Exclude) > 0 {
+		text += ", except those tagged #" + strings.Join(c.Exclude, ", #")
+	}
+	return text + "."
+}
+
+// ownedMirrors returns the mirrors posting with tokens of telegramID.
+func ownedMirrors(ctx context.Context, telegramID int) ([]string, []*channelMirror, error) {
+	keys, err := recordStore.RecordKeys(ctx, channelMirrorKind, "")
+	if err != nil {
+		return nil, nil, err
+	}
+	var owned []string
+	var mirrors []*channelMirror
+	for _, key := range keys {
+		mirror := &channelMirror{}
+		if err := recordStore.GetRecord(ctx, channelMirrorKind, key, mirror); err != nil {
+			continue
+		}
+		if mirror.TelegramID == telegramID {
+			owned = append(owned, key)
+			mirrors = append(mirrors, mirror)
+		}
+	}
+	return owned, mirrors, nil
+}
+
+func listChannels(ctx context.Context, m *tb.Message) {
+	_, mirrors, err := ownedMirrors(ctx, m.Sender.ID)
+	if err != nil {
+		log.Println("list channel mirrors error ", err)
+		return
+	}
+	if len(mirrors) == 0 {
+		bot.Send(m.Sender, "No channels are mirrored. Add the bot to your channel as an administrator, then send /channel @name link.")
+		return
+	}
+	var b strings.Builder
+	for _, c := range mirrors {
+		state := "on"
+		if c.Disabled {
+			state = "off"
+		}
+		fmt.Fprintf(&b, "%s → %s (%s). %s\n", c.name(), c.Account, state, mirrorFilters(c))
+	}
+	bot.Send(m.Sender, b.String())
+}
+
+// purgeChannelMirrors deletes the mirrors owned by telegramID and what
+// they posted.
+func purgeChannelMirrors(ctx context.Context, telegramID int) (int, error) {
+	keys, _, err := ownedMirrors(ctx, telegramID)
+	if err != nil {
+		return 0, err
+	}
+	for i, key := range keys {
+		posts, err := recordStore.RecordKeys(ctx, channelPostKind, key+":")
+		if err != nil {
+			return i, err
+		}
+		for _, post := range posts {
+			if err := recordStore.DeleteRecord(ctx, channelPostKind, post); err != nil {
+				return i, err
+			}
+		}
+		if err := recordStore.DeleteRecord(ctx, channelMirrorKind, key); err != nil {
+			return i, err
+		}
+	}
+	return len(keys), nil
+}
+
+// mirrorFor returns the enabled mirror of the channel m was posted in and
+// the account it posts as, or false when m is not to be mirrored.
+func mirrorFor(ctx context.Context, m *tb.Message) (*channelMirror, *oauthInfo, bool) {
+	mirror := &channelMirror{}
+	if err := recordStore.GetRecord(ctx, channelMirrorKind, chatKey(m.Chat.ID), mirror); err != nil || mirror.Disabled {
+		return nil, nil, false
+	}
+	if !mirror.accepts(m) {
+		return nil, nil, false
+	}
+	info, err := tokenStore.Get(ctx, mirror.TelegramID, mirror.Account)
+	if err != nil {
+		log.Println("get key error ", err)
+		// The account was unlinked; stop until the owner links another.
+		mirror.Disabled = true
+		recordStore.PutRecord(ctx, channelMirrorKind, chatKey(m.Chat.ID), mirror)
+		bot.Send(&tb.User{ID: mirror.TelegramID}, fmt.Sprintf("Mirroring of %s is off because %s is no longer linked. Send /channel %s link to mirror it again.",
+			mirror.name(), mirror.Account, mirror.name()))
+		return nil, nil, false
+	}
+	return mirror, info, true
+}
+
+// accepts reports whether the hashtags of m pass the filters of c.
+func (c *channelMirror) accepts(m *tb.Message) bool {
+	text := strings.ToLower(m.Text + " " + m.Caption)
+	tags := hashtagPattern.FindAllString(text, -1)
+	hasTag := func(list []string) bool {
+		for _, tag := range tags {
+			if containsString(list, strings.TrimPrefix(tag, "#")) {
+				return true
+			}
+		}
+		return false
+	}
+	return (len(c.Include) == 0 || hasTag(c.Include)) && !hasTag(c.Exclude)
+}
+
+// channelFooter is the link back to m, or "" when not wanted.
+func channelFooter(mirror *channelMirror, m *tb.Message) string {
+	if !mirror.LinkBack {
+		return ""
+	}
+	if mirror.Username != "" {
+		return fmt.Sprintf(" https://t.me/%s/%d", mirror.Username, m.ID)
+	}
+	// Links to private channels open for their members only.
+	return fmt.Sprintf(" https://t.me/c/%s/%d", strings.TrimPrefix(chatKey(m.Chat.ID), "-100"), m.ID)
+}
+
+func handleChannelPost(m *tb.Message) {
+	ctx := context.Background()
+	mirror, info, ok := mirrorFor(ctx, m)
+	if !ok {
+		return
+	}
+	mirrorChannelPost(ctx, mirror, info, m)
+}
+
+// handleEditedChannelPost replaces the statuses mirrored from a post
+// with its new version. Posts edited so that the filters no longer pass
+// are only deleted.
+func handleEditedChannelPost(m *tb.Message) {
+	ctx := context.Background()
+	posted := &postedMessage{}
+	key := chatKey(m.Chat.ID) + ":" + strconv.Itoa(m.ID)
+	if err := recordStore.GetRecord(ctx, channelPostKind, key, posted); err != nil {
+		return
+	}
+	current := &channelMirror{}
+	if err := recordStore.GetRecord(ctx, channelMirrorKind, chatKey(m.Chat.ID), current); err != nil || current.Disabled {
+		return
+	}
+	// The channel may be mirrored by someone else by now; the old
+	// statuses go with the token of whoever posted them.
+	owner := posted.TelegramID
+	if owner == 0 {
+		owner = current.TelegramID
+	}
+	for _, old := range posted.Statuses {
+		info, err := tokenStore.Get(ctx, owner, old.Account)
+		if err != nil {
+			// Posting again would leave the old version next to the new.
+			log.Println("get key error ", err)
+			return
+		}
+		for _, id := range append([]string{old.StatusID}, old.Thread...) {
+			if _, err := clientFor(info).DestroyStatus(id); err != nil {
+				log.Println("destroy edited status error ", err)
+			}
+		}
+	}
+	if err := recordStore.DeleteRecord(ctx, channelPostKind, key); err != nil {
+		log.Println("delete channel post error ", err)
+	}
+	mirror, info, ok := mirrorFor(ctx, m)
+	if !ok {
+		return
+	}
+	mirrorChannelPost(ctx, mirror, info, m)
+}
+
+// mirrorChannelPost posts the text or photo of m. Long text becomes a
+// numbered thread, as nobody is there to preview it.
+func mirrorChannelPost(ctx context.Context, mirror *channelMirror, info *oauthInfo, m *tb.Message) {
+	owner := &tb.User{ID: mirror.TelegramID}
+	footer := channelFooter(mirror, m)
+	var statuses []*fanfou.Status
+	var err error
+	switch {
+	case m.Photo != nil:
+		var data []byte
+		var filename string
+		if data, filename, err = downloadFile(m.Photo.FileID); err != nil {
+			log.Println("get file error ", err)
+			return
+		}
+		if data, filename, _, err = preparePhoto(data, filename); err != nil {
+			log.Println("prepare photo error ", err)
+			return
+		}
+		caption := m.Caption
+		if caption == "" {
+			caption = "Just posted a photo"
+		}
+		params := &fanfou.StatusParams{Status: truncateStatus(caption, statusLimit-statusLength(footer)) + footer}
+		var status *fanfou.Status
+		if status, err = clientFor(info).UploadPhoto(params, filename, bytes.NewReader(data)); err == nil {
+			statuses = append(statuses, status)
+		}
+	case m.Text != "":
+		statuses, err = postThread(info, postedStatus{}, splitStatus(m.Text+footer, statusLimit, true))
+	default:
+		return
+	}
+	if err != nil {
+		log.Println("mirror channel post error ", err)
+		sendFanfouError(bot, owner, err)
+	}
+	if len(statuses) == 0 {
+		return
+	}
+	posted := postedStatus{Account: info.FanfouID, StatusID: statuses[0].ID}
+	for _, s := range statuses[1:] {
+		posted.Thread = append(posted.Thread, s.ID)
+	}
+	key := chatKey(m.Chat.ID) + ":" + strconv.Itoa(m.ID)
+	if err := recordStore.PutRecord(ctx, channelPostKind, key, &postedMessage{Statuses: []postedStatus{posted}, TelegramID: mirror.TelegramID}); err != nil {
+		log.Println("remember channel post error ", err)
+	}
+}
+
+// truncateStatus cuts text to limit characters, marking the cut.
+func truncateStatus(text string, limit int) string {
+	if statusLength(text) <= limit {
+		return text
+	}
+	return string([]rune(text)[:limit-1]) + "…"
+}
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pyzh/fanfou-telegram-bot/fanfou"
+)
+
+const defaultTelegramAPIURL = "https://api.telegram.org"
+
+// Config holds the bot settings. Keys are the env_variables names of
+// app.yaml.example.
+type Config struct {
+	ConsumerKey    string
+	ConsumerSecret string
+	TelegramToken  string
+	ProjectID      string
+
+	// PublicURL is the address the server is reachable at. CallbackURL
+	// and the webhook URL are derived from it.
+	PublicURL   string
+	CallbackURL string
+	ListenAddr  string
+
+	FanfouAPIURL   string
+	FanfouOAuthURL string
+	TelegramAPIURL string
+
+	// TokenStore selects the storage backend for tokens and all other
+	// bot state: "datastore", "file" or "memory".
+	TokenStore     string
+	TokenStorePath string
+	// TokenKeys lists the AES keys wrapping the data keys of access
+	// tokens as "id:base64key,...". The first one wraps, all of them
+	// unwrap.
+	TokenKeys string
+
+	PollerMode    string
+	WebhookSecret string
+
+	// CallbackSecret signs inline button data. It defaults to a value
+	// derived from TelegramToken.
+	CallbackSecret string
+	// UndoGracePeriod is how long the Undo button of a receipt works, as
+	// a Go duration such as "60s".
+	UndoGracePeriod string
+	// MaxPhotoBytes and MaxPhotoPixels bound uploaded photos: larger ones
+	// are recompressed, longer sides are scaled down.
+	MaxPhotoBytes  string
+	MaxPhotoPixels string
+	// ForwardTemplate formats forwarded messages; {name}, {link} and
+	// {text} are replaced by the source, its t.me link and the text.
+	ForwardTemplate string
+
+	undoGrace      time.Duration
+	maxPhotoBytes  int
+	maxPhotoPixels int
+}
+
+func (c *Config) fields() map[string]*string {
+	return map[string]*string{
+		"ConsumerKey":     &c.ConsumerKey,
+		"ConsumerSecret":  &c.ConsumerSecret,
+		"TelegramToken":   &c.TelegramToken,
+		"ProjectID":       &c.ProjectID,
+		"PublicURL":       &c.PublicURL,
+		"CallbackURL":     &c.CallbackURL,
+		"ListenAddr":      &c.ListenAddr,
+		"FanfouAPIURL":    &c.FanfouAPIURL,
+		"FanfouOAuthURL":  &c.FanfouOAuthURL,
+		"TelegramAPIURL":  &c.TelegramAPIURL,
+		"TokenStore":      &c.TokenStore,
+		"TokenStorePath":  &c.TokenStorePath,
+		"TokenKeys":       &c.TokenKeys,
+		"PollerMode":      &c.PollerMode,
+		"WebhookSecret":   &c.WebhookSecret,
+		"CallbackSecret":  &c.CallbackSecret,
+		"UndoGracePeriod": &c.UndoGracePeriod,
+		"MaxPhotoBytes":   &c.MaxPhotoBytes,
+		"MaxPhotoPixels":  &c.MaxPhotoPixels,
+		"ForwardTemplate": &c.ForwardTemplate,
+	}
+}
+
+// loadConfig reads the optional config file at path, then lets
+// environment variables override it, fills in defaults and validates the
+// result.
+func loadConfig(path string) (*Config, error) {
+	c := &Config{}
+	fields := c.fields()
+	if path != "" {
+		if err := readConfigFile(path, fields); err != nil {
+			return nil, err
+		}
+	}
+	for key, field := range fields {
+		if v, ok := os.LookupEnv(key); ok {
+			*field = v
+		}
+	}
+	c.setDefaults()
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// readConfigFile understands flat "Key: value" (YAML) and "Key = value"
+// (TOML) lines, so an app.yaml can be used as is. Unknown keys, comments
+// and section headers are skipped. Values are single-line strings,
+// optionally quoted; a "#" after a space ends an unquoted value.
+func readConfigFile(path string, fields map[string]*string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' || line[0] == '[' {
+			continue
+		}
+		i := strings.IndexAny(line, ":=")
+		if i < 0 {
+			continue
+		}
+		field, ok := fields[strings.TrimSpace(line[:i])]
+		if !ok {
+			continue
+		}
+		*field = configValue(strings.TrimSpace(line[i+1:]))
+	}
+	return scanner.Err()
+}
+
+// configValue strips the quotes or the trailing comment of v.
+func configValue(v string) string {
+	if len(v) >= 2 && (v[0] == '"' || v[0] == '\'') {
+		if end := strings.IndexByte(v[1:], v[0]); end >= 0 {
+			return v[1 : end+1]
+		}
+	}
+	if strings.HasPrefix(v, "#") {
+		return ""
+	}
+	for i := 1; i < len(v); i++ {
+		if v[i] == '#' && (v[i-1] == ' ' || v[i-1] == '\t') {
+			return strings.TrimSpace(v[:i])
+		}
+	}
+	return v
+}
+
+func (c *Config) setDefaults() {
+	c.PublicURL = strings.TrimSuffix(c.PublicURL, "/")
+	if c.CallbackURL == "" && c.PublicURL != "" {
+		c.CallbackURL = c.PublicURL + "/callback"
+	}
+	if c.ListenAddr == "" {
+		c.ListenAddr = ":8080"
+		if port := os.Getenv("PORT"); port != "" {
+			c.ListenAddr = ":" + port
+		}
+	}
+	if c.FanfouAPIURL == "" {
+		c.FanfouAPIURL = fanfou.DefaultBaseURL
+	}
+	if c.FanfouOAuthURL == "" {
+		c.FanfouOAuthURL = fanfou.DefaultOAuthURL
+	}
+	if c.TelegramAPIURL == "" {
+		c.TelegramAPIURL = defaultTelegramAPIURL
+	}
+	c.FanfouAPIURL = strings.TrimSuffix(c.FanfouAPIURL, "/") + "/"
+	c.FanfouOAuthURL = strings.TrimSuffix(c.FanfouOAuthURL, "/") + "/"
+	c.TelegramAPIURL = strings.TrimSuffix(c.TelegramAPIURL, "/")
+	if c.TokenStore == "" {
+		c.TokenStore = "datastore"
+	}
+	if c.PollerMode == "" {
+		c.PollerMode = "longpoll"
+	}
+	if c.CallbackSecret == "" {
+		sum := sha256.Sum256([]byte("callback:" + c.TelegramToken))
+		c.CallbackSecret = hex.EncodeToString(sum[:])
+	}
+	if c.UndoGracePeriod == "" {
+		c.UndoGracePeriod = "60s"
+	}
+	if c.MaxPhotoBytes == "" {
+		c.MaxPhotoBytes = "2097152"
+	}
+	if c.MaxPhotoPixels == "" {
+		c.MaxPhotoPixels = "2048"
+	}
+	if c.ForwardTemplate == "" {
+		c.ForwardTemplate = "转发自 {name}: {text} {link}"
+	}
+}
+
+// webhookSecretPattern is what Telegram accepts as a secret_token.
+var webhookSecretPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,256}$`)
+
+func (c *Config) validate() error {
+	var problems []string
+	for _, key := range []string{"ConsumerKey", "ConsumerSecret", "TelegramToken"} {
+		if *c.fields()[key] == "" {
+			problems = append(problems, key+" is required")
+		}
+	}
+	if c.CallbackURL == "" {
+		problems = append(problems, "PublicURL or CallbackURL is required")
+	}
+	for key, v := range map[string]string{
+		"PublicURL":      c.PublicURL,
+		"CallbackURL":    c.CallbackURL,
+		"FanfouAPIURL":   c.FanfouAPIURL,
+		"FanfouOAuthURL": c.FanfouOAuthURL,
+		"TelegramAPIURL": c.TelegramAPIURL,
+	} {
+		if v == "" {
+			continue
+		}
+		if u, err := url.Parse(v); err != nil || u.Scheme == "" || u.Host == "" {
+			problems = append(problems, key+" must be an absolute URL")
+		}
+	}
+	switch c.TokenStore {
+	case "datastore", "memory":
+	case "file":
+		if c.TokenStorePath == "" {
+			problems = append(problems, "TokenStorePath is required for the file token store")
+		}
+	default:
+		problems = append(problems, fmt.Sprintf("unknown TokenStore %q", c.TokenStore))
+	}
+	if c.TokenKeys != "" {
+		if _, _, err := parseTokenKeys(c.TokenKeys); err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+	switch c.PollerMode {
+	case "longpoll":
+	case "webhook":
+		if c.PublicURL == "" || c.WebhookSecret == "" {
+			problems = append(problems, "webhook mode needs PublicURL and WebhookSecret")
+		} else if !webhookSecretPattern.MatchString(c.WebhookSecret) {
+			problems = append(problems, "WebhookSecret must be 1 to 256 of A-Z, a-z, 0-9, _ and -")
+		}
+	default:
+		problems = append(problems, fmt.Sprintf("unknown PollerMode %q", c.PollerMode))
+	}
+	var err error
+	if c.undoGrace, err = time.ParseDuration(c.UndoGracePeriod); err != nil || c.undoGrace < 0 {
+		problems = append(problems, "UndoGracePeriod must be a duration such as 60s")
+	}
+	if c.maxPhotoBytes, err = strconv.Atoi(c.MaxPhotoBytes); err != nil || c.maxPhotoBytes < 10000 {
+		problems = append(problems, "MaxPhotoBytes must be a number of bytes, at least 10000")
+	}
+	if c.maxPhotoPixels, err = strconv.Atoi(c.MaxPhotoPixels); err != nil || c.maxPhotoPixels < 100 {
+		problems = append(problems, "MaxPhotoPixels must be a number of pixels, at least 100")
+	}
+	if !strings.Contains(c.ForwardTemplate, "{text}") {
+		problems = append(problems, "ForwardTemplate must contain {text}")
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid config: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// telegramClient returns the HTTP client for Bot API calls. telebot
+// always talks to api.telegram.org, so requests are rewritten when a
+// different TelegramAPIURL is configured.
+func (c *Config) telegramClient() *http.Client {
+	if c.TelegramAPIURL == defaultTelegramAPIURL {
+		return http.DefaultClient
+	}
+	u, _ := url.Parse(c.TelegramAPIURL)
+	return &http.Client{Transport: &rewriteTransport{base: u}}
+}
+
+type rewriteTransport struct {
+	base *url.URL
+}
+
+func (t *rewriteTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	r := new(http.Request)
+	*r = *req
+	u := *req.URL
+	u.Scheme = t.base.Scheme
+	u.Host = t.base.Host
+	u.Path = strings.TrimSuffix(t.base.Path, "/") + req.URL.Path
+	r.URL = &u
+	r.Host = ""
+	return http.DefaultTransport.RoundTrip(r)
+}
@@ -0,0 +1,23 @@
+package main
+
+import "testing"
+
+func TestConfigValue(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{`plain`, "plain"},
+		{`value # comment`, "value"},
+		{"value\t# comment", "value"},
+		{`# comment`, ""},
+		{`"quoted # not a comment" # comment`, "quoted # not a comment"},
+		{`'single'`, "single"},
+		{`https://example.com/#fragment`, "https://example.com/#fragment"},
+		{`"unterminated`, `"unterminated`},
+	}
+	for _, tt := range tests {
+		if got := configValue(tt.in); got != tt.want {
+			t.Errorf("configValue(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+)
+
+// encryptedTokenStore seals access tokens before they reach the wrapped
+// TokenStore, using envelope encryption: every token gets a random data
+// key that encrypts Token and Secret with AES-GCM, and only the data key
+// is sealed with one of the configured keys, whose ID is saved next to
+// it. Rotating keys thus re-wraps data keys only: tokens under an older
+// key have their data key re-sealed with the primary key the next time
+// they are read. Tokens saved before encryption was enabled are encrypted
+// then too.
+type encryptedTokenStore struct {
+	TokenStore
+	keys    map[string]cipher.AEAD
+	primary string
+}
+
+// dataKeySize is the size of the per-token AES-256 data keys.
+const dataKeySize = 32
+
+// parseTokenKeys parses "id:base64key,id:base64key". The first key is the
+// primary one, the others are only used for decryption.
+func parseTokenKeys(spec string) (keys map[string]cipher.AEAD, primary string, err error) {
+	keys = map[string]cipher.AEAD{}
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		i := strings.Index(entry, ":")
+		if i <= 0 {
+			return nil, "", fmt.Errorf("token key %q is not id:base64key", entry)
+		}
+		id := entry[:i]
+		raw, err := base64.StdEncoding.DecodeString(entry[i+1:])
+		if err != nil {
+			return nil, "", fmt.Errorf("token key %s: %v", id, err)
+		}
+		aead, err := newAEAD(raw)
+		if err != nil {
+			return nil, "", fmt.Errorf("token key %s: %v", id, err)
+		}
+		if _, ok := keys[id]; ok {
+			return nil, "", fmt.Errorf("token key %s is listed twice", id)
+		}
+		keys[id] = aead
+		if primary == "" {
+			primary = id
+		}
+	}
+	return keys, primary, nil
+}
+
+func newEncryptedTokenStore(s TokenStore, spec string) (*encryptedTokenStore, error) {
+	keys, primary, err := parseTokenKeys(spec)
+	if err != nil {
+		return nil, err
+	}
+	return &encryptedTokenStore{TokenStore: s, keys: keys, primary: primary}, nil
+}
+
+func (s *encryptedTokenStore) Get(ctx context.Context, telegramID int, fanfouID string) (*oauthInfo, error) {
+	stored, err := s.TokenStore.Get(ctx, telegramID, fanfouID)
+	if err != nil {
+		return nil, err
+	}
+	return s.open(ctx, telegramID, stored)
+}
+
+func (s *encryptedTokenStore) List(ctx context.Context, telegramID int) ([]*oauthInfo, error) {
+	stored, err := s.TokenStore.List(ctx, telegramID)
+	if err != nil {
+		return nil, err
+	}
+	infos := make([]*oauthInfo, len(stored))
+	for i, st := range stored {
+		if infos[i], err = s.open(ctx, telegramID, st); err != nil {
+			return nil, err
+		}
+	}
+	return infos, nil
+}
+
+// open decrypts stored and moves it to the primary key if it isn't
+// under it yet.
+func (s *encryptedTokenStore) open(ctx context.Context, telegramID int, stored *oauthInfo) (*oauthInfo, error) {
+	info, err := s.decrypt(telegramID, stored)
+	if err != nil {
+		return nil, err
+	}
+	if stored.KeyID == s.primary && stored.DataKey != "" {
+		return info, nil
+	}
+	if stored.DataKey != "" {
+		err = s.rewrap(ctx, telegramID, stored)
+	} else {
+		err = s.Put(ctx, telegramID, info)
+	}
+	if err != nil {
+		log.Println("re-encrypt token error ", err)
+	}
+	return info, nil
+}
+
+func (s *encryptedTokenStore) Put(ctx context.Context, telegramID int, info *oauthInfo) error {
+	sealed, err := s.encrypt(telegramID, info)
+	if err != nil {
+		return err
+	}
+	return s.TokenStore.Put(ctx, telegramID, sealed)
+}
+
+// rewrap seals the data key of stored with the primary key, leaving the
+// encrypted token as it is.
+func (s *encryptedTokenStore) rewrap(ctx context.Context, telegramID int, stored *oauthInfo) error {
+	dataKey, err := unseal(s.keys[stored.KeyID], stored.DataKey, tokenAD(telegramID, "data key"))
+	if err != nil {
+		return err
+	}
+	wrapped := *stored
+	wrapped.KeyID = s.primary
+	if wrapped.DataKey, err = seal(s.keys[s.primary], dataKey, tokenAD(telegramID, "data key")); err != nil {
+		return err
+	}
+	return s.TokenStore.Put(ctx, telegramID, &wrapped)
+}
+
+func (s *encryptedTokenStore) encrypt(telegramID int, info *oauthInfo) (*oauthInfo, error) {
+	dataKey := make([]byte, dataKeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	sealed := *info
+	sealed.KeyID = s.primary
+	if sealed.DataKey, err = seal(s.keys[s.primary], dataKey, tokenAD(telegramID, "data key")); err != nil {
+		return nil, err
+	}
+	if sealed.Token, err = seal(aead, []byte(info.Token), tokenAD(telegramID, "token")); err != nil {
+		return nil, err
+	}
+	if sealed.Secret, err = seal(aead, []byte(info.Secret), tokenAD(telegramID, "secret")); err != nil {
+		return nil, err
+	}
+	return &sealed, nil
+}
+
+func (s *encryptedTokenStore) decrypt(telegramID int, stored *oauthInfo) (*oauthInfo, error) {
+	info := *stored
+	info.KeyID, info.DataKey = "", ""
+	if stored.KeyID == "" {
+		// Saved before encryption was turned on.
+		return &info, nil
+	}
+	aead, ok := s.keys[stored.KeyID]
+	if !ok {
+		return nil, fmt.Errorf("token of user %d is encrypted with unknown key %q", telegramID, stored.KeyID)
+	}
+	// Tokens without a data key were sealed with the configured key
+	// itself, before envelope encryption.
+	if stored.DataKey != "" {
+		dataKey, err := unseal(aead, stored.DataKey, tokenAD(telegramID, "data key"))
+		if err != nil {
+			return nil, err
+		}
+		if aead, err = newAEAD(dataKey); err != nil {
+			return nil, err
+		}
+	}
+	token, err := unseal(aead, stored.Token, tokenAD(telegramID, "token"))
+	if err != nil {
+		return nil, err
+	}
+	secret, err := unseal(aead, stored.Secret, tokenAD(telegramID, "secret"))
+	if err != nil {
+		return nil, err
+	}
+	info.Token, info.Secret = string(token), string(secret)
+	return &info, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal encrypts plain under a random nonce and encodes nonce and
+// ciphertext together.
+func seal(aead cipher.AEAD, plain, ad []byte) (string, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(aead.Seal(nonce, nonce, plain, ad)), nil
+}
+
+func unseal(aead cipher.AEAD, sealed string, ad []byte) ([]byte, error) {
+	raw, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil {
+		return nil, err
+	}
+	if len(raw) < aead.NonceSize() {
+		return nil, errors.New("encrypted token is too short")
+	}
+	return aead.Open(nil, raw[:aead.NonceSize()], raw[aead.NonceSize():], ad)
+}
+
+// tokenAD binds a ciphertext to its owner and field, so encrypted values
+// can't be swapped between users or between token, secret and data key.
+func tokenAD(telegramID int, field string) []byte {
+	return []byte(strconv.Itoa(telegramID) + "/" + field)
+}
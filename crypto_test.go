@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+var (
+	testKeyOld = "old:" + base64.StdEncoding.EncodeToString([]byte(strings.Repeat("o", 32)))
+	testKeyNew = "new:" + base64.StdEncoding.EncodeToString([]byte(strings.Repeat("n", 32)))
+)
+
+func newTestEncryptedStore(t *testing.T, backing TokenStore, spec string) *encryptedTokenStore {
+	t.Helper()
+	s, err := newEncryptedTokenStore(backing, spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// rawToken reads what the encrypted store saved underneath.
+func rawToken(t *testing.T, backing TokenStore, telegramID int, fanfouID string) *oauthInfo {
+	t.Helper()
+	info, err := backing.Get(context.Background(), telegramID, fanfouID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return info
+}
+
+func TestEncryptedTokenStoreRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	backing := newMemoryStore()
+	s := newTestEncryptedStore(t, backing, testKeyOld)
+	want := oauthInfo{Token: "tok", Secret: "sec", FanfouID: "alice", ScreenName: "Alice"}
+	if err := s.Put(ctx, 1, &want); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Put(ctx, 1, &oauthInfo{Token: "tok", Secret: "sec", FanfouID: "bob"}); err != nil {
+		t.Fatal(err)
+	}
+
+	raw := rawToken(t, backing, 1, "alice")
+	if raw.KeyID != "old" || raw.DataKey == "" || raw.Token == "tok" || raw.Secret == "sec" {
+		t.Errorf("stored token is not sealed: %+v", raw)
+	}
+	if other := rawToken(t, backing, 1, "bob"); other.DataKey == raw.DataKey || other.Token == raw.Token {
+		t.Error("two tokens share a data key or ciphertext")
+	}
+
+	got, err := s.Get(ctx, 1, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *got != want {
+		t.Errorf("Get = %+v, want %+v", *got, want)
+	}
+	infos, err := s.List(ctx, 1)
+	if err != nil || len(infos) != 2 || *infos[0] != want {
+		t.Errorf("List = %+v, %v", infos, err)
+	}
+}
+
+func TestEncryptedTokenStoreRotation(t *testing.T) {
+	ctx := context.Background()
+	backing := newMemoryStore()
+	if err := newTestEncryptedStore(t, backing, testKeyOld).Put(ctx, 1, &oauthInfo{Token: "tok", Secret: "sec"}); err != nil {
+		t.Fatal(err)
+	}
+	before := rawToken(t, backing, 1, "")
+
+	s := newTestEncryptedStore(t, backing, testKeyNew+","+testKeyOld)
+	got, err := s.Get(ctx, 1, "")
+	if err != nil || got.Token != "tok" || got.Secret != "sec" {
+		t.Fatalf("Get = %+v, %v", got, err)
+	}
+	after := rawToken(t, backing, 1, "")
+	if after.KeyID != "new" || after.DataKey == before.DataKey {
+		t.Errorf("data key not re-wrapped with the primary key: %+v", after)
+	}
+	// Only the data key changes.
+	if after.Token != before.Token || after.Secret != before.Secret {
+		t.Error("token re-encrypted instead of re-wrapping its data key")
+	}
+	// The old key is no longer needed.
+	got, err = newTestEncryptedStore(t, backing, testKeyNew).Get(ctx, 1, "")
+	if err != nil || got.Token != "tok" {
+		t.Errorf("Get with the new key only = %+v, %v", got, err)
+	}
+}
+
+func TestEncryptedTokenStoreLegacy(t *testing.T) {
+	ctx := context.Background()
+	backing := newMemoryStore()
+	s := newTestEncryptedStore(t, backing, testKeyOld)
+
+	// Saved before encryption was turned on.
+	backing.Put(ctx, 1, &oauthInfo{Token: "tok", Secret: "sec"})
+	// Sealed with the configured key itself, before data keys.
+	aead := s.keys["old"]
+	token, _ := seal(aead, []byte("tok2"), tokenAD(2, "token"))
+	secret, _ := seal(aead, []byte("sec2"), tokenAD(2, "secret"))
+	backing.Put(ctx, 2, &oauthInfo{Token: token, Secret: secret, KeyID: "old"})
+
+	for id, want := range map[int]string{1: "tok", 2: "tok2"} {
+		got, err := s.Get(ctx, id, "")
+		if err != nil || got.Token != want || got.KeyID != "" || got.DataKey != "" {
+			t.Errorf("user %d: Get = %+v, %v", id, got, err)
+			continue
+		}
+		if raw := rawToken(t, backing, id, ""); raw.KeyID != "old" || raw.DataKey == "" || raw.Token == want {
+			t.Errorf("user %d: legacy token not re-encrypted: %+v", id, raw)
+		}
+		if got, err := s.Get(ctx, id, ""); err != nil || got.Token != want {
+			t.Errorf("user %d: Get after re-encryption = %+v, %v", id, got, err)
+		}
+	}
+}
+
+func TestEncryptedTokenStoreRejects(t *testing.T) {
+	ctx := context.Background()
+	backing := newMemoryStore()
+	s := newTestEncryptedStore(t, backing, testKeyOld)
+	if err := s.Put(ctx, 1, &oauthInfo{Token: "tok", Secret: "sec"}); err != nil {
+		t.Fatal(err)
+	}
+	sealed := *rawToken(t, backing, 1, "")
+
+	unknown := sealed
+	unknown.KeyID = "gone"
+	moved := sealed
+	swapped := sealed
+	swapped.Token, swapped.Secret = sealed.Secret, sealed.Token
+	tests := []struct {
+		name       string
+		telegramID int
+		stored     oauthInfo
+	}{
+		{"unknown key ID", 1, unknown},
+		{"moved to another user", 2, moved},
+		{"token and secret swapped", 1, swapped},
+	}
+	for _, tt := range tests {
+		stored := tt.stored
+		backing.Put(ctx, tt.telegramID, &stored)
+		if got, err := s.Get(ctx, tt.telegramID, ""); err == nil {
+			t.Errorf("%s: Get = %+v, want an error", tt.name, got)
+		}
+	}
+}
+
+func TestParseTokenKeys(t *testing.T) {
+	short := base64.StdEncoding.EncodeToString([]byte("short"))
+	for _, spec := range []string{
+		"",
+		"nokey",
+		":" + strings.SplitN(testKeyOld, ":", 2)[1],
+		"a:not base64!",
+		"a:" + short,
+		testKeyOld + "," + testKeyOld,
+	} {
+		if _, _, err := parseTokenKeys(spec); err == nil {
+			t.Errorf("parseTokenKeys(%q) succeeded", spec)
+		}
+	}
+	keys, primary, err := parseTokenKeys(testKeyNew + ", " + testKeyOld)
+	if err != nil || primary != "new" || len(keys) != 2 {
+		t.Errorf("parseTokenKeys = %d keys, %q, %v", len(keys), primary, err)
+	}
+}
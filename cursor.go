@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// accountCursor tracks what was delivered from a feed of one linked
+// account, such as its mentions or its inbox. Cursors are keyed by
+// userKey(telegramID, fanfouID).
+type accountCursor struct {
+	// Disabled pauses delivery.
+	Disabled bool `json:",omitempty"`
+	// SinceID is the newest item already delivered.
+	SinceID string
+	// Initialized is set once the first poll recorded where to start,
+	// even if there was nothing yet.
+	Initialized bool
+}
+
+// cursorItem is a new item of a feed and how to deliver it.
+type cursorItem struct {
+	ID      string
+	deliver func() error
+}
+
+// cursorPoller delivers new items of a feed to every account with a
+// cursor of kind.
+type cursorPoller struct {
+	kind string
+	// fetch returns the items of info newer than sinceID, newest first.
+	fetch func(ctx context.Context, telegramID int, info *oauthInfo, sinceID string) ([]cursorItem, error)
+}
+
+// watchCursor starts a cursor of kind for a linked account, keeping an
+// existing one.
+func watchCursor(ctx context.Context, kind string, telegramID int, fanfouID string) error {
+	key := userKey(telegramID, fanfouID)
+	err := recordStore.GetRecord(ctx, kind, key, &accountCursor{})
+	if err != errNoRecord {
+		return err
+	}
+	return recordStore.PutRecord(ctx, kind, key, &accountCursor{})
+}
+
+// run polls every cursor of p once per tick.
+func (p *cursorPoller) run(ctx context.Context, tick time.Duration) {
+	for range time.Tick(tick) {
+		keys, err := recordStore.RecordKeys(ctx, p.kind, "")
+		if err != nil {
+			log.Println("list "+p.kind+" error ", err)
+			continue
+		}
+		for _, key := range keys {
+			parts := strings.SplitN(key, ":", 2)
+			telegramID, err := strconv.Atoi(parts[0])
+			if err != nil || len(parts) != 2 {
+				continue
+			}
+			if err := p.poll(ctx, telegramID, parts[1]); err != nil {
+				log.Println("poll "+p.kind+" error ", err)
+			}
+		}
+	}
+}
+
+func (p *cursorPoller) poll(ctx context.Context, telegramID int, fanfouID string) error {
+	key := userKey(telegramID, fanfouID)
+	cursor := &accountCursor{}
+	if err := recordStore.GetRecord(ctx, p.kind, key, cursor); err != nil {
+		return err
+	}
+	if cursor.Disabled {
+		return nil
+	}
+	info, err := tokenStore.Get(ctx, telegramID, fanfouID)
+	if err == errNoToken {
+		return recordStore.DeleteRecord(ctx, p.kind, key)
+	}
+	if err != nil {
+		return err
+	}
+
+	items, err := p.fetch(ctx, telegramID, info, cursor.SinceID)
+	if err != nil {
+		return err
+	}
+	if !cursor.Initialized && cursor.SinceID == "" {
+		// First run: start from here instead of replaying old items.
+		var sinceID string
+		if len(items) > 0 {
+			sinceID = items[0].ID
+		}
+		return ignoreNoRecord(p.advance(ctx, key, sinceID))
+	}
+	for i := len(items) - 1; i >= 0; i-- {
+		if err := items[i].deliver(); err != nil {
+			return err
+		}
+		if err := p.advance(ctx, key, items[i].ID); err != nil {
+			return ignoreNoRecord(err)
+		}
+	}
+	return nil
+}
+
+// advance records sinceID as delivered, keeping whatever commands changed
+// meanwhile. It returns errNoRecord if the cursor was deleted.
+func (p *cursorPoller) advance(ctx context.Context, key, sinceID string) error {
+	cur := &accountCursor{}
+	return recordStore.UpdateRecord(ctx, p.kind, key, cur, func() error {
+		cur.Initialized = true
+		if sinceID != "" {
+			cur.SinceID = sinceID
+		}
+		return nil
+	})
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"log"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/pyzh/fanfou-telegram-bot/fanfou"
+	tb "gopkg.in/tucnak/telebot.v2"
+)
+
+const (
+	dmCursorKind  = "dm_cursors"
+	dmMessageKind = "dm_messages"
+)
+
+const inboxPageSize = 5
+
+// dmRef ties a forwarded direct message to its Fanfou sender, so a reply
+// in Telegram can be sent back.
+type dmRef struct {
+	Account    string
+	DMID       string
+	UserID     string
+	ScreenName string
+}
+
+var (
+	inboxPrevBtn = tb.InlineButton{Unique: "inbox_prev", Text: "« Newer"}
+	inboxNextBtn = tb.InlineButton{Unique: "inbox_next", Text: "Older »"}
+)
+
+func directMessageHTML(dm *fanfou.DirectMessage) string {
+	return fmt.Sprintf("✉️ <b>%s</b> <a href=\"%s\">@%s</a>\n%s",
+		html.EscapeString(dm.SenderScreenName), fanfou.UserURL(dm.SenderID),
+		html.EscapeString(dm.SenderID), html.EscapeString(html.UnescapeString(dm.Text)))
+}
+
+// dmPoller forwards new direct messages of linked accounts.
+var dmPoller = &cursorPoller{kind: dmCursorKind, fetch: fetchDirectMessages}
+
+func fetchDirectMessages(ctx context.Context, telegramID int, info *oauthInfo, sinceID string) ([]cursorItem, error) {
+	dms, err := clientFor(info).Inbox(&fanfou.TimelineParams{SinceID: sinceID, Count: 20})
+	if err != nil {
+		return nil, err
+	}
+	user := &tb.User{ID: telegramID}
+	items := make([]cursorItem, len(dms))
+	for i := range dms {
+		dm := &dms[i]
+		items[i] = cursorItem{ID: dm.ID, deliver: func() error {
+			m, err := bot.Send(user, directMessageHTML(dm)+"\n<i>Reply to this message to answer.</i>", tb.ModeHTML)
+			if err != nil {
+				return err
+			}
+			ref := &dmRef{Account: info.FanfouID, DMID: dm.ID, UserID: dm.SenderID, ScreenName: dm.SenderScreenName}
+			if err := recordStore.PutRecord(ctx, dmMessageKind, userKey(telegramID, strconv.Itoa(m.ID)), ref); err != nil {
+				log.Println("remember dm error ", err)
+			}
+			return nil
+		}}
+	}
+	return items, nil
+}
+
+// handleDirectMessageReply sends m back to Fanfou when it replies to a
+// forwarded direct message, and reports whether it did.
+func handleDirectMessageReply(ctx context.Context, m *tb.Message) bool {
+	ref := &dmRef{}
+	err := recordStore.GetRecord(ctx, dmMessageKind, userKey(m.Sender.ID, strconv.Itoa(m.ReplyTo.ID)), ref)
+	if err != nil {
+		return false
+	}
+	info, err := tokenStore.Get(ctx, m.Sender.ID, ref.Account)
+	if err != nil {
+		bot.Send(m.Sender, ref.Account+" is no longer linked.")
+		return true
+	}
+	if _, err := clientFor(info).NewDirectMessage(ref.UserID, m.Text, ref.DMID); err != nil {
+		log.Println("send direct message error ", err)
+		sendFanfouError(bot, m.Sender, err)
+		return true
+	}
+	bot.Send(m.Sender, "Sent to "+ref.ScreenName+".")
+	return true
+}
+
+// handleDM implements "/dm @user text".
+func handleDM(m *tb.Message) {
+	var user string
+	text := commandText(m)
+	if i := strings.IndexFunc(text, unicode.IsSpace); i >= 0 {
+		user, text = text[:i], strings.TrimSpace(text[i:])
+	}
+	if !strings.HasPrefix(user, "@") || len(user) < 2 || text == "" {
+		bot.Send(m.Sender, "Usage: /dm @user text")
+		return
+	}
+	client, err := newFanfouClient(context.Background(), m.Sender.ID)
+	if err == errNoToken {
+		bot.Send(m.Sender, "Link a Fanfou account with /start first.")
+		return
+	}
+	if err != nil {
+		log.Println("get key error ", err)
+		return
+	}
+	dm, err := client.NewDirectMessage(user[1:], text, "")
+	if err != nil {
+		log.Println("send direct message error ", err)
+		sendFanfouError(bot, m.Sender, err)
+		return
+	}
+	bot.Send(m.Sender, "Sent to "+dm.RecipientScreenName+".")
+}
+
+func handleInbox(m *tb.Message) {
+	text, markup, err := inboxView(context.Background(), m.Sender.ID, 1)
+	if err != nil {
+		log.Println("get inbox error ", err)
+		sendFanfouError(bot, m.Sender, err)
+		return
+	}
+	bot.Send(m.Sender, text, markup, tb.ModeHTML, tb.NoPreview)
+}
+
+func handleInboxPage(c *tb.Callback) {
+	page, err := strconv.Atoi(c.Data)
+	if err != nil || page < 1 {
+		bot.Respond(c)
+		return
+	}
+	text, markup, err := inboxView(context.Background(), c.Sender.ID, page)
+	if err != nil {
+		log.Println("get inbox error ", err)
+		bot.Respond(c, &tb.CallbackResponse{Text: "Failed, please try again."})
+		return
+	}
+	bot.Respond(c)
+	bot.Edit(c.Message, text, markup, tb.ModeHTML, tb.NoPreview)
+}
+
+// inboxView renders one page of the active account's inbox.
+func inboxView(ctx context.Context, telegramID, page int) (string, *tb.ReplyMarkup, error) {
+	client, err := newFanfouClient(ctx, telegramID)
+	if err == errNoToken {
+		return "Link a Fanfou account with /start first.", &tb.ReplyMarkup{}, nil
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	dms, err := client.Inbox(&fanfou.TimelineParams{Count: inboxPageSize, Page: page})
+	if err != nil {
+		return "", nil, err
+	}
+	if len(dms) == 0 && page == 1 {
+		return "Your inbox is empty.", &tb.ReplyMarkup{}, nil
+	}
+
+	entries := make([]string, len(dms))
+	for i := range dms {
+		entries[i] = directMessageHTML(&dms[i])
+	}
+	text := fmt.Sprintf("<b>Inbox, page %d</b>\n\n%s", page, strings.Join(entries, "\n\n"))
+	if len(dms) == 0 {
+		text = fmt.Sprintf("<b>Inbox, page %d</b>\n\nNo older messages.", page)
+	}
+
+	var row []tb.InlineButton
+	if page > 1 {
+		prev := inboxPrevBtn
+		prev.Data = strconv.Itoa(page - 1)
+		row = append(row, prev)
+	}
+	if len(dms) == inboxPageSize {
+		next := inboxNextBtn
+		next.Data = strconv.Itoa(page + 1)
+		row = append(row, next)
+	}
+	markup := &tb.ReplyMarkup{}
+	if len(row) > 0 {
+		markup.InlineKeyboard = [][]tb.InlineButton{row}
+	}
+	return text, markup, nil
+}
@@ -0,0 +1,107 @@
+// Package fanfou is a small client for the Fanfou REST API.
+package fanfou
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/dghubble/oauth1"
+)
+
+// DefaultBaseURL is the root of the Fanfou REST API.
+const DefaultBaseURL = "http://api.fanfou.com/"
+
+// DefaultOAuthURL is the root of the Fanfou OAuth endpoints.
+const DefaultOAuthURL = "http://fanfou.com/oauth/"
+
+// Endpoint is the Fanfou OAuth 1.0a endpoint.
+var Endpoint = OAuthEndpoint(DefaultOAuthURL)
+
+// OAuthEndpoint returns the OAuth 1.0a endpoint rooted at baseURL, which
+// must end with a slash.
+func OAuthEndpoint(baseURL string) oauth1.Endpoint {
+	return oauth1.Endpoint{
+		RequestTokenURL: baseURL + "request_token",
+		AuthorizeURL:    baseURL + "authorize",
+		AccessTokenURL:  baseURL + "access_token",
+	}
+}
+
+// StatusURL returns the web page of the status with the given id.
+func StatusURL(id string) string {
+	return "https://fanfou.com/statuses/" + id
+}
+
+// UserURL returns the profile page of the user with the given id.
+func UserURL(id string) string {
+	return "https://fanfou.com/" + url.PathEscape(id)
+}
+
+// Client calls the Fanfou API on behalf of a single user.
+type Client struct {
+	// BaseURL is the API root, with a trailing slash.
+	BaseURL string
+
+	httpClient *http.Client
+}
+
+// NewClient returns a client signing requests with config and token.
+func NewClient(config *oauth1.Config, token *oauth1.Token) *Client {
+	return &Client{
+		BaseURL:    DefaultBaseURL,
+		httpClient: config.Client(oauth1.NoContext, token),
+	}
+}
+
+func (c *Client) get(path string, params url.Values, v interface{}) error {
+	u := c.BaseURL + path
+	if len(params) > 0 {
+		u += "?" + params.Encode()
+	}
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return err
+	}
+	return c.do(req, v)
+}
+
+func (c *Client) post(path string, params url.Values, v interface{}) error {
+	req, err := http.NewRequest("POST", c.BaseURL+path, strings.NewReader(params.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return c.do(req, v)
+}
+
+func (c *Client) postMultipart(path, contentType string, body io.Reader, v interface{}) error {
+	req, err := http.NewRequest("POST", c.BaseURL+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	return c.do(req, v)
+}
+
+func (c *Client) do(req *http.Request, v interface{}) error {
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return newError(resp.StatusCode, body)
+	}
+	if v == nil {
+		return nil
+	}
+	return json.Unmarshal(body, v)
+}
@@ -0,0 +1,42 @@
+package fanfou
+
+import "net/url"
+
+// Inbox returns direct messages received by the user.
+func (c *Client) Inbox(params *TimelineParams) ([]DirectMessage, error) {
+	return c.directMessages("direct_messages/inbox.json", params)
+}
+
+// Sent returns direct messages sent by the user.
+func (c *Client) Sent(params *TimelineParams) ([]DirectMessage, error) {
+	return c.directMessages("direct_messages/sent.json", params)
+}
+
+// NewDirectMessage sends text to the user with id user. inReplyToID may
+// be empty.
+func (c *Client) NewDirectMessage(user, text, inReplyToID string) (*DirectMessage, error) {
+	v := url.Values{"user": {user}, "text": {text}}
+	setOptional(v, "in_reply_to_id", inReplyToID)
+	dm := &DirectMessage{}
+	if err := c.post("direct_messages/new.json", v, dm); err != nil {
+		return nil, err
+	}
+	return dm, nil
+}
+
+// DestroyDirectMessage deletes the direct message with the given id.
+func (c *Client) DestroyDirectMessage(id string) (*DirectMessage, error) {
+	dm := &DirectMessage{}
+	if err := c.post("direct_messages/destroy.json", url.Values{"id": {id}}, dm); err != nil {
+		return nil, err
+	}
+	return dm, nil
+}
+
+func (c *Client) directMessages(path string, params *TimelineParams) ([]DirectMessage, error) {
+	var dms []DirectMessage
+	if err := c.get(path, params.values(), &dms); err != nil {
+		return nil, err
+	}
+	return dms, nil
+}
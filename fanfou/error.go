@@ -0,0 +1,26 @@
+package fanfou
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// Error is returned when the Fanfou API answers with a non-200 status.
+type Error struct {
+	StatusCode int    `json:"-"`
+	Request    string `json:"request"`
+	Message    string `json:"error"`
+}
+
+func newError(statusCode int, body []byte) *Error {
+	e := &Error{StatusCode: statusCode}
+	if err := json.Unmarshal(body, e); err != nil || e.Message == "" {
+		e.Message = http.StatusText(statusCode)
+	}
+	return e
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("fanfou: %s (%d %s)", e.Message, e.StatusCode, e.Request)
+}
@@ -0,0 +1,70 @@
+package fanfou
+
+import "time"
+
+// Status is a single Fanfou message.
+type Status struct {
+	ID                  string  `json:"id"`
+	RawID               int64   `json:"rawid"`
+	Text                string  `json:"text"`
+	Source              string  `json:"source"`
+	CreatedAt           string  `json:"created_at"`
+	Truncated           bool    `json:"truncated"`
+	Favorited           bool    `json:"favorited"`
+	Location            string  `json:"location"`
+	InReplyToStatusID   string  `json:"in_reply_to_status_id"`
+	InReplyToUserID     string  `json:"in_reply_to_user_id"`
+	InReplyToScreenName string  `json:"in_reply_to_screen_name"`
+	RepostStatusID      string  `json:"repost_status_id"`
+	RepostStatus        *Status `json:"repost_status"`
+	User                *User   `json:"user"`
+	Photo               *Photo  `json:"photo"`
+}
+
+// Time parses CreatedAt.
+func (s *Status) Time() (time.Time, error) {
+	return time.Parse(time.RubyDate, s.CreatedAt)
+}
+
+// Photo is the image attached to a status.
+type Photo struct {
+	ImageURL string `json:"imageurl"`
+	ThumbURL string `json:"thumburl"`
+	LargeURL string `json:"largeurl"`
+}
+
+// User is a Fanfou account. ID is the login name used in URLs and API
+// parameters, ScreenName is the display name.
+type User struct {
+	ID                   string  `json:"id"`
+	Name                 string  `json:"name"`
+	ScreenName           string  `json:"screen_name"`
+	Location             string  `json:"location"`
+	Gender               string  `json:"gender"`
+	Description          string  `json:"description"`
+	ProfileImageURL      string  `json:"profile_image_url"`
+	ProfileImageURLLarge string  `json:"profile_image_url_large"`
+	URL                  string  `json:"url"`
+	Protected            bool    `json:"protected"`
+	Following            bool    `json:"following"`
+	FollowersCount       int     `json:"followers_count"`
+	FriendsCount         int     `json:"friends_count"`
+	FavouritesCount      int     `json:"favourites_count"`
+	StatusesCount        int     `json:"statuses_count"`
+	CreatedAt            string  `json:"created_at"`
+	Status               *Status `json:"status"`
+}
+
+// DirectMessage is a private message between two users.
+type DirectMessage struct {
+	ID                  string         `json:"id"`
+	Text                string         `json:"text"`
+	SenderID            string         `json:"sender_id"`
+	RecipientID         string         `json:"recipient_id"`
+	SenderScreenName    string         `json:"sender_screen_name"`
+	RecipientScreenName string         `json:"recipient_screen_name"`
+	CreatedAt           string         `json:"created_at"`
+	Sender              *User          `json:"sender"`
+	Recipient           *User          `json:"recipient"`
+	InReplyTo           *DirectMessage `json:"in_reply_to"`
+}
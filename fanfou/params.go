@@ -0,0 +1,74 @@
+package fanfou
+
+import (
+	"net/url"
+	"strconv"
+)
+
+// StatusParams describes a new status.
+type StatusParams struct {
+	Status            string
+	InReplyToStatusID string
+	InReplyToUserID   string
+	RepostStatusID    string
+	Location          string
+}
+
+func (p *StatusParams) values() url.Values {
+	v := url.Values{}
+	v.Set("status", p.Status)
+	setOptional(v, "in_reply_to_status_id", p.InReplyToStatusID)
+	setOptional(v, "in_reply_to_user_id", p.InReplyToUserID)
+	setOptional(v, "repost_status_id", p.RepostStatusID)
+	setOptional(v, "location", p.Location)
+	return v
+}
+
+// TimelineParams selects a page of a timeline. ID names the user for
+// per-user timelines and is ignored elsewhere.
+type TimelineParams struct {
+	ID      string
+	SinceID string
+	MaxID   string
+	Count   int
+	Page    int
+}
+
+func (p *TimelineParams) values() url.Values {
+	v := url.Values{}
+	if p == nil {
+		return v
+	}
+	setOptional(v, "id", p.ID)
+	setOptional(v, "since_id", p.SinceID)
+	setOptional(v, "max_id", p.MaxID)
+	if p.Count > 0 {
+		v.Set("count", strconv.Itoa(p.Count))
+	}
+	if p.Page > 0 {
+		v.Set("page", strconv.Itoa(p.Page))
+	}
+	return v
+}
+
+// SearchParams is a search query, optionally limited to the timeline of
+// user ID.
+type SearchParams struct {
+	Query   string
+	ID      string
+	SinceID string
+	MaxID   string
+	Count   int
+}
+
+func (p *SearchParams) values() url.Values {
+	v := (&TimelineParams{ID: p.ID, SinceID: p.SinceID, MaxID: p.MaxID, Count: p.Count}).values()
+	v.Set("q", p.Query)
+	return v
+}
+
+func setOptional(v url.Values, key, value string) {
+	if value != "" {
+		v.Set(key, value)
+	}
+}
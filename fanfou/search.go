@@ -0,0 +1,20 @@
+package fanfou
+
+// SearchPublicTimeline searches all public statuses.
+func (c *Client) SearchPublicTimeline(params *SearchParams) ([]Status, error) {
+	var statuses []Status
+	if err := c.get("search/public_timeline.json", params.values(), &statuses); err != nil {
+		return nil, err
+	}
+	return statuses, nil
+}
+
+// SearchUserTimeline searches statuses of params.ID, or of the user when
+// ID is empty.
+func (c *Client) SearchUserTimeline(params *SearchParams) ([]Status, error) {
+	var statuses []Status
+	if err := c.get("search/user_timeline.json", params.values(), &statuses); err != nil {
+		return nil, err
+	}
+	return statuses, nil
+}
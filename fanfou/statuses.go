@@ -0,0 +1,126 @@
+package fanfou
+
+import (
+	"bytes"
+	"io"
+	"mime/multipart"
+	"net/url"
+)
+
+// UpdateStatus posts a new status.
+func (c *Client) UpdateStatus(params *StatusParams) (*Status, error) {
+	status := &Status{}
+	if err := c.post("statuses/update.json", params.values(), status); err != nil {
+		return nil, err
+	}
+	return status, nil
+}
+
+// UploadPhoto posts a new status with photo attached. The RepostStatusID
+// field of params is not used.
+func (c *Client) UploadPhoto(params *StatusParams, filename string, photo io.Reader) (*Status, error) {
+	body := new(bytes.Buffer)
+	w := multipart.NewWriter(body)
+	if err := w.WriteField("status", params.Status); err != nil {
+		return nil, err
+	}
+	for _, f := range []struct{ name, value string }{
+		{"in_reply_to_status_id", params.InReplyToStatusID},
+		{"in_reply_to_user_id", params.InReplyToUserID},
+		{"location", params.Location},
+	} {
+		if f.value == "" {
+			continue
+		}
+		if err := w.WriteField(f.name, f.value); err != nil {
+			return nil, err
+		}
+	}
+	part, err := w.CreateFormFile("photo", filename)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.Copy(part, photo); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	status := &Status{}
+	if err := c.postMultipart("photos/upload.json", w.FormDataContentType(), body, status); err != nil {
+		return nil, err
+	}
+	return status, nil
+}
+
+// DestroyStatus deletes one of the user's own statuses.
+func (c *Client) DestroyStatus(id string) (*Status, error) {
+	status := &Status{}
+	if err := c.post("statuses/destroy.json", url.Values{"id": {id}}, status); err != nil {
+		return nil, err
+	}
+	return status, nil
+}
+
+// ShowStatus fetches a single status.
+func (c *Client) ShowStatus(id string) (*Status, error) {
+	status := &Status{}
+	if err := c.get("statuses/show.json", url.Values{"id": {id}}, status); err != nil {
+		return nil, err
+	}
+	return status, nil
+}
+
+// HomeTimeline returns statuses of the user and the people they follow.
+func (c *Client) HomeTimeline(params *TimelineParams) ([]Status, error) {
+	return c.timeline("statuses/home_timeline.json", params)
+}
+
+// UserTimeline returns statuses posted by params.ID, or by the user when
+// ID is empty.
+func (c *Client) UserTimeline(params *TimelineParams) ([]Status, error) {
+	return c.timeline("statuses/user_timeline.json", params)
+}
+
+// PublicTimeline returns the latest public statuses.
+func (c *Client) PublicTimeline(params *TimelineParams) ([]Status, error) {
+	return c.timeline("statuses/public_timeline.json", params)
+}
+
+// Mentions returns statuses mentioning the user.
+func (c *Client) Mentions(params *TimelineParams) ([]Status, error) {
+	return c.timeline("statuses/mentions.json", params)
+}
+
+// Favorites returns statuses favorited by params.ID, or by the user when
+// ID is empty.
+func (c *Client) Favorites(params *TimelineParams) ([]Status, error) {
+	return c.timeline("favorites.json", params)
+}
+
+// CreateFavorite favorites the status with the given id.
+func (c *Client) CreateFavorite(id string) (*Status, error) {
+	status := &Status{}
+	if err := c.post("favorites/create/"+url.PathEscape(id)+".json", url.Values{}, status); err != nil {
+		return nil, err
+	}
+	return status, nil
+}
+
+// DestroyFavorite removes the status with the given id from favorites.
+func (c *Client) DestroyFavorite(id string) (*Status, error) {
+	status := &Status{}
+	if err := c.post("favorites/destroy/"+url.PathEscape(id)+".json", url.Values{}, status); err != nil {
+		return nil, err
+	}
+	return status, nil
+}
+
+func (c *Client) timeline(path string, params *TimelineParams) ([]Status, error) {
+	var statuses []Status
+	if err := c.get(path, params.values(), &statuses); err != nil {
+		return nil, err
+	}
+	return statuses, nil
+}
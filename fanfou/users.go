@@ -0,0 +1,48 @@
+package fanfou
+
+import "net/url"
+
+// VerifyCredentials returns the user the client is authorized as.
+func (c *Client) VerifyCredentials() (*User, error) {
+	user := &User{}
+	if err := c.get("account/verify_credentials.json", nil, user); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// ShowUser fetches the profile of the user with the given id.
+func (c *Client) ShowUser(id string) (*User, error) {
+	user := &User{}
+	if err := c.get("users/show.json", url.Values{"id": {id}}, user); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// CreateFriendship follows the user with the given id.
+func (c *Client) CreateFriendship(id string) (*User, error) {
+	user := &User{}
+	if err := c.post("friendships/create.json", url.Values{"id": {id}}, user); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// DestroyFriendship unfollows the user with the given id.
+func (c *Client) DestroyFriendship(id string) (*User, error) {
+	user := &User{}
+	if err := c.post("friendships/destroy.json", url.Values{"id": {id}}, user); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// FriendshipExists reports whether userA follows userB.
+func (c *Client) FriendshipExists(userA, userB string) (bool, error) {
+	var exists bool
+	if err := c.get("friendships/exists.json", url.Values{"user_a": {userA}, "user_b": {userB}}, &exists); err != nil {
+		return false, err
+	}
+	return exists, nil
+}
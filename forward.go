@@ -0,0 +1,245 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	tb "gopkg.in/tucnak/telebot.v2"
+)
+
+const forwardDraftKind = "forward_drafts"
+
+// Forward modes, chosen with /forwards.
+const (
+	forwardAttribute = "attribute"
+	forwardStrip     = "strip"
+	forwardAsk       = "ask"
+)
+
+var (
+	forwardAttributeBtn = tb.InlineButton{Unique: "forward_attribute", Text: "With source"}
+	forwardStripBtn     = tb.InlineButton{Unique: "forward_strip", Text: "Without source"}
+	forwardCancelBtn    = tb.InlineButton{Unique: "forward_cancel", Text: "Cancel"}
+)
+
+// forwardDraft is a forwarded text waiting for the user to choose
+// whether to credit its source.
+type forwardDraft struct {
+	Text       string
+	Attributed string
+}
+
+// forwardOrigin holds the forward details of a message that the vendored
+// telebot doesn't decode.
+type forwardOrigin struct {
+	// Signature is the author signature of a forwarded channel post.
+	Signature string `json:"forward_signature"`
+	// SenderName is set instead of forward_from for senders who hide
+	// their account.
+	SenderName string `json:"forward_sender_name"`
+	// MessageID is the ID of a forwarded channel post in its channel.
+	MessageID int `json:"forward_from_message_id"`
+}
+
+// forwardOriginsKept bounds how many forward origins are remembered
+// until their messages are handled.
+const forwardOriginsKept = 1000
+
+var (
+	forwardOriginsMu   sync.Mutex
+	forwardOrigins     = map[string]forwardOrigin{}
+	forwardOriginOrder []string
+)
+
+func forwardOriginKey(m *tb.Message) string {
+	if m.Chat == nil {
+		return ""
+	}
+	return fmt.Sprintf("%d:%d", m.Chat.ID, m.ID)
+}
+
+// rememberForwardOrigin keeps o for lookupForwardOrigin, forgetting the
+// oldest origins beyond forwardOriginsKept.
+func rememberForwardOrigin(m *tb.Message, o *forwardOrigin) {
+	key := forwardOriginKey(m)
+	if key == "" || *o == (forwardOrigin{}) {
+		return
+	}
+	forwardOriginsMu.Lock()
+	defer forwardOriginsMu.Unlock()
+	if _, ok := forwardOrigins[key]; !ok {
+		forwardOriginOrder = append(forwardOriginOrder, key)
+	}
+	forwardOrigins[key] = *o
+	if len(forwardOriginOrder) > forwardOriginsKept {
+		delete(forwardOrigins, forwardOriginOrder[0])
+		forwardOriginOrder = forwardOriginOrder[1:]
+	}
+}
+
+func lookupForwardOrigin(m *tb.Message) forwardOrigin {
+	forwardOriginsMu.Lock()
+	defer forwardOriginsMu.Unlock()
+	return forwardOrigins[forwardOriginKey(m)]
+}
+
+// isForward reports whether m was forwarded. Senders who hide their
+// account leave only the date of the original message.
+func isForward(m *tb.Message) bool {
+	return m.OriginalUnixtime != 0 || m.IsForwarded()
+}
+
+// forwardSource names where m was forwarded from, with a t.me link when
+// the source is public. Channel posts are credited to their signed
+// author too and linked directly.
+func forwardSource(m *tb.Message) (name, link string) {
+	origin := lookupForwardOrigin(m)
+	switch {
+	case m.OriginalChat != nil:
+		name = m.OriginalChat.Title
+		if origin.Signature != "" {
+			name = fmt.Sprintf("%s (%s)", name, origin.Signature)
+		}
+		if m.OriginalChat.Username != "" {
+			link = "https://t.me/" + m.OriginalChat.Username
+			if origin.MessageID != 0 {
+				link += "/" + strconv.Itoa(origin.MessageID)
+			}
+		}
+	case m.OriginalSender != nil:
+		name = strings.TrimSpace(m.OriginalSender.FirstName + " " + m.OriginalSender.LastName)
+		if m.OriginalSender.Username != "" {
+			link = "https://t.me/" + m.OriginalSender.Username
+		}
+	default:
+		name = origin.SenderName
+	}
+	if name == "" {
+		name = "a hidden user"
+	}
+	return name, link
+}
+
+// attributeForward fills the ForwardTemplate with text and the source of
+// m.
+func attributeForward(m *tb.Message, text string) string {
+	name, link := forwardSource(m)
+	return attributeText(name, link, text)
+}
+
+// attributeText fills the ForwardTemplate. Empty placeholders are
+// dropped with the spaces before them or, when they start a line, with
+// the spaces and line break after them. text is kept as it is.
+func attributeText(name, link, text string) string {
+	tmpl := config.ForwardTemplate
+	for placeholder, value := range map[string]string{"{name}": name, "{link}": link} {
+		if value == "" {
+			tmpl = emptyPlaceholderPattern(placeholder).ReplaceAllString(tmpl, "")
+		}
+	}
+	return strings.NewReplacer("{name}", name, "{link}", link, "{text}", text).Replace(tmpl)
+}
+
+func emptyPlaceholderPattern(placeholder string) *regexp.Regexp {
+	p := regexp.QuoteMeta(placeholder)
+	return regexp.MustCompile(`(?m)^` + p + `[ \t]*\n?|[ \t]*` + p)
+}
+
+// forwardText returns the text to post for a forwarded m, and false when
+// the user is to be asked first.
+func forwardText(ctx context.Context, m *tb.Message, text string) (string, bool) {
+	settings, err := getSettings(ctx, m.Sender.ID)
+	if err != nil {
+		log.Println("get settings error ", err)
+	}
+	mode := forwardAttribute
+	if settings != nil && settings.ForwardMode != "" {
+		mode = settings.ForwardMode
+	}
+	switch mode {
+	case forwardStrip:
+		return text, true
+	case forwardAsk:
+		return "", false
+	}
+	return attributeForward(m, text), true
+}
+
+// askForward saves the forwarded text m and asks how to post it.
+func askForward(ctx context.Context, m *tb.Message) {
+	msgID := strconv.Itoa(m.ID)
+	d := &forwardDraft{Text: m.Text, Attributed: attributeForward(m, m.Text)}
+	if err := recordStore.PutRecord(ctx, forwardDraftKind, userKey(m.Sender.ID, msgID), d); err != nil {
+		log.Println("save forward draft error ", err)
+		return
+	}
+	attribute, strip, cancel := forwardAttributeBtn, forwardStripBtn, forwardCancelBtn
+	attribute.Data = signCallback(m.Sender.ID, msgID)
+	strip.Data, cancel.Data = attribute.Data, attribute.Data
+	bot.Send(m.Sender, "Post this forward with its source?\n\n"+d.Attributed,
+		&tb.ReplyMarkup{InlineKeyboard: [][]tb.InlineButton{{attribute, strip}, {cancel}}})
+}
+
+func handleForwardAttribute(c *tb.Callback) {
+	postForward(c, true)
+}
+
+func handleForwardStrip(c *tb.Callback) {
+	postForward(c, false)
+}
+
+func postForward(c *tb.Callback, attributed bool) {
+	ctx := context.Background()
+	msgID, ok := verifyCallback(c.Sender.ID, c.Data)
+	if !ok {
+		bot.Respond(c, &tb.CallbackResponse{Text: "This button is not for you.", ShowAlert: true})
+		return
+	}
+	d := &forwardDraft{}
+	if err := recordStore.TakeRecord(ctx, forwardDraftKind, userKey(c.Sender.ID, msgID), d); err != nil {
+		bot.Respond(c, &tb.CallbackResponse{Text: "This forward is gone."})
+		return
+	}
+	bot.Respond(c)
+	bot.Delete(c.Message)
+	text := d.Text
+	if attributed {
+		text = d.Attributed
+	}
+	// Receipts and the edit record belong to the forwarded message.
+	id, _ := strconv.Atoi(msgID)
+	postText(ctx, &tb.Message{ID: id, Sender: c.Sender, Chat: &tb.Chat{ID: int64(c.Sender.ID), Type: tb.ChatPrivate}}, text)
+}
+
+func handleForwardCancel(c *tb.Callback) {
+	ctx := context.Background()
+	if msgID, ok := verifyCallback(c.Sender.ID, c.Data); ok {
+		if err := recordStore.DeleteRecord(ctx, forwardDraftKind, userKey(c.Sender.ID, msgID)); err != nil {
+			log.Println("delete forward draft error ", err)
+		}
+	}
+	handleCancel(c)
+}
+
+func handleForwards(m *tb.Message) {
+	mode := strings.TrimSpace(m.Payload)
+	switch mode {
+	case forwardAttribute, forwardStrip, forwardAsk:
+	default:
+		bot.Send(m.Sender, "Usage: /forwards attribute|strip|ask. Forwarded messages are posted crediting their source, as if you wrote them, or you are asked each time.")
+		return
+	}
+	err := updateSettings(context.Background(), m.Sender.ID, func(s *userSettings) {
+		s.ForwardMode = mode
+	})
+	if err != nil {
+		log.Println("save settings error ", err)
+		return
+	}
+	bot.Send(m.Sender, "Forwards will be posted in "+mode+" mode.")
+}
@@ -0,0 +1,110 @@
+package main
+
+import "testing"
+
+func TestDecodeUpdate(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     string
+		wantName string
+		wantLink string
+	}{
+		{
+			"signed channel post",
+			`{"update_id":1,"message":{"message_id":5,"chat":{"id":901},"forward_date":3,
+				"forward_from_chat":{"id":-100,"title":"News","username":"news"},
+				"forward_signature":"Ann","forward_from_message_id":42,"text":"hi"}}`,
+			"News (Ann)", "https://t.me/news/42",
+		},
+		{
+			"private channel",
+			`{"update_id":2,"message":{"message_id":6,"chat":{"id":901},"forward_date":3,
+				"forward_from_chat":{"id":-100,"title":"Secret"},"forward_from_message_id":7,"text":"hi"}}`,
+			"Secret", "",
+		},
+		{
+			"hidden sender",
+			`{"update_id":3,"message":{"message_id":7,"chat":{"id":901},"forward_date":3,
+				"forward_sender_name":"Bob","text":"hi"}}`,
+			"Bob", "",
+		},
+		{
+			"user",
+			`{"update_id":4,"message":{"message_id":8,"chat":{"id":901},"forward_date":3,
+				"forward_from":{"id":1,"first_name":"Carol","last_name":"Li","username":"carol"},"text":"hi"}}`,
+			"Carol Li", "https://t.me/carol",
+		},
+		{
+			"hidden sender without a name",
+			`{"update_id":5,"message":{"message_id":9,"chat":{"id":901},"forward_date":3,"text":"hi"}}`,
+			"a hidden user", "",
+		},
+	}
+	for _, tt := range tests {
+		upd, err := decodeUpdate([]byte(tt.data))
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if upd.Message == nil || upd.Message.Text != "hi" || !isForward(upd.Message) {
+			t.Errorf("%s: message not decoded: %+v", tt.name, upd.Message)
+			continue
+		}
+		name, link := forwardSource(upd.Message)
+		if name != tt.wantName || link != tt.wantLink {
+			t.Errorf("%s: forwardSource = %q, %q; want %q, %q", tt.name, name, link, tt.wantName, tt.wantLink)
+		}
+	}
+
+	if _, err := decodeUpdate([]byte(`{"update_id":`)); err == nil {
+		t.Error("bad JSON decoded")
+	}
+	upd, err := decodeUpdate([]byte(`{"update_id":6,"callback_query":{"id":"1","data":"x"}}`))
+	if err != nil || upd.Callback == nil || upd.Message != nil {
+		t.Errorf("callback update = %+v, %v", upd, err)
+	}
+}
+
+func TestAttributeText(t *testing.T) {
+	saved := config
+	defer func() { config = saved }()
+
+	tests := []struct {
+		template   string
+		name, link string
+		text       string
+		want       string
+	}{
+		{"转发自 {name}: {text} {link}", "Ann", "https://t.me/ann", "hi", "转发自 Ann: hi https://t.me/ann"},
+		{"转发自 {name}: {text} {link}", "Ann", "", "hi", "转发自 Ann: hi"},
+		{"转发自 {name}: {text} {link}", "Ann", "", "line one\n\nline two", "转发自 Ann: line one\n\nline two"},
+		{"{text}  {link}\n— {name}", "Ann", "", "hi", "hi\n— Ann"},
+		{"{link}\n{text}\n— {name}", "Ann", "", "a  b", "a  b\n— Ann"},
+		{"{link} {text}", "", "", "hi", "hi"},
+	}
+	for _, tt := range tests {
+		config = &Config{ForwardTemplate: tt.template}
+		if got := attributeText(tt.name, tt.link, tt.text); got != tt.want {
+			t.Errorf("attributeText(%q, %q, %q) with %q = %q, want %q", tt.name, tt.link, tt.text, tt.template, got, tt.want)
+		}
+	}
+}
+
+func TestEmptyPlaceholderPattern(t *testing.T) {
+	tests := []struct {
+		template, want string
+	}{
+		{"{text} {link}", "{text}"},
+		{"{text}\t {link} end", "{text} end"},
+		{"{link} {text}", "{text}"},
+		{"{link}\n{text}", "{text}"},
+		{"{text}\n{link}\nend", "{text}\nend"},
+		{"{text}{link}", "{text}"},
+		{"{text}", "{text}"},
+	}
+	for _, tt := range tests {
+		if got := emptyPlaceholderPattern("{link}").ReplaceAllString(tt.template, ""); got != tt.want {
+			t.Errorf("dropping {link} from %q = %q, want %q", tt.template, got, tt.want)
+		}
+	}
+}
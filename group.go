@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"log"
+	"strings"
+	"unicode"
+
+	tb "gopkg.in/tucnak/telebot.v2"
+)
+
+const groupHelp = `Hi! In groups I only post when asked:
+/ff <text> posts text to your own Fanfou account.
+Mention me in a message to post it, or mention me in a reply to post the message replied to.
+/team and /propose let the group share one account with approval.
+Link your account first by sending me /start in a private chat.`
+
+// privateOnly ignores messages outside private chats, so the bot doesn't
+// post everything said in a group.
+func privateOnly(h func(*tb.Message)) func(*tb.Message) {
+	return func(m *tb.Message) {
+		if m.Private() {
+			h(m)
+		}
+	}
+}
+
+func handleAddedToGroup(m *tb.Message) {
+	bot.Send(m.Chat, groupHelp)
+}
+
+// commandText is the text after the /command(@bot) that m starts with.
+// Unlike m.Payload, which telebot ends at the first line break, it keeps
+// every line.
+func commandText(m *tb.Message) string {
+	i := strings.IndexFunc(m.Text, unicode.IsSpace)
+	if i < 0 {
+		return ""
+	}
+	return strings.TrimSpace(m.Text[i:])
+}
+
+// handleFF posts the text after /ff to the sender's own account, in any
+// chat.
+func handleFF(m *tb.Message) {
+	text := commandText(m)
+	if text == "" {
+		bot.Reply(m, "Usage: /ff <text>")
+		return
+	}
+	postFromGroup(context.Background(), m, text)
+}
+
+// handleGroupText posts a group message that mentions the bot. A reply
+// with nothing but the mention posts the message replied to, crediting
+// its author.
+func handleGroupText(ctx context.Context, m *tb.Message) {
+	mention := "@" + bot.Me.Username
+	if !strings.Contains(strings.ToLower(m.Text), strings.ToLower(mention)) {
+		return
+	}
+	text := strings.TrimSpace(replaceFold(m.Text, mention, ""))
+	if text == "" && m.ReplyTo != nil && m.ReplyTo.Text != "" {
+		text = m.ReplyTo.Text
+		if from := m.ReplyTo.Sender; from != nil && from.ID != m.Sender.ID {
+			link := ""
+			if from.Username != "" {
+				link = "https://t.me/" + from.Username
+			}
+			text = attributeText(strings.TrimSpace(from.FirstName+" "+from.LastName), link, text)
+		}
+	}
+	if text == "" {
+		bot.Reply(m, "Mention me with some text, or in a reply, to post it to Fanfou.")
+		return
+	}
+	postFromGroup(ctx, m, text)
+}
+
+// postFromGroup posts text for the sender of m. Receipts go to the
+// private chat; the group only hears about problems.
+func postFromGroup(ctx context.Context, m *tb.Message, text string) {
+	if _, err := activeAccount(ctx, m.Sender.ID); err != nil {
+		log.Println("get key error ", err)
+		bot.Reply(m, "Link your Fanfou account first by sending me /start in a private chat.")
+		return
+	}
+	postText(ctx, m, text)
+}
+
+// replaceFold replaces old in s regardless of case.
+func replaceFold(s, old, new string) string {
+	i := strings.Index(strings.ToLower(s), strings.ToLower(old))
+	if i < 0 {
+		return s
+	}
+	return s[:i] + new + s[i+len(old):]
+}
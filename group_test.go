@@ -0,0 +1,25 @@
+package main
+
+import (
+	"testing"
+
+	tb "gopkg.in/tucnak/telebot.v2"
+)
+
+func TestCommandText(t *testing.T) {
+	tests := []struct {
+		text, want string
+	}{
+		{"/ff hello", "hello"},
+		{"/ff line one\nline two", "line one\nline two"},
+		{"/ff\nfirst line\n\nsecond", "first line\n\nsecond"},
+		{"/ff@fanfou_bot  spaced  ", "spaced"},
+		{"/ff", ""},
+		{"/ff@fanfou_bot", ""},
+	}
+	for _, tt := range tests {
+		if got := commandText(&tb.Message{Text: tt.text}); got != tt.want {
+			t.Errorf("commandText(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"path"
+	"strings"
+)
+
+// maxDecodePixels caps the size of images the bot decodes. A small file
+// can claim huge dimensions and make decoding allocate gigabytes.
+const maxDecodePixels = 50000000
+
+var errImageTooLarge = errors.New("image dimensions too large")
+
+// decodeImage decodes data unless it declares more than maxDecodePixels.
+func decodeImage(data []byte) (image.Image, string, error) {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", err
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 || int64(cfg.Width)*int64(cfg.Height) > maxDecodePixels {
+		return nil, "", errImageTooLarge
+	}
+	return image.Decode(bytes.NewReader(data))
+}
+
+// preparePhoto makes data fit for Fanfou: EXIF and XMP metadata is
+// removed, JPEGs are turned upright, and images larger than the configured
+// limits are scaled down and recompressed. GIFs are left alone to keep their
+// animation. It returns the new data and filename with a note per change.
+func preparePhoto(data []byte, filename string) ([]byte, string, []string, error) {
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", nil, err
+	}
+	if int64(cfg.Width)*int64(cfg.Height) > maxDecodePixels {
+		return nil, "", nil, errImageTooLarge
+	}
+	var notes []string
+	orientation := 1
+	switch format {
+	case "gif":
+		return data, filename, nil, nil
+	case "jpeg":
+		var stripped bool
+		data, orientation, stripped = stripJPEGExif(data)
+		if stripped {
+			notes = append(notes, "removed EXIF/XMP metadata")
+		}
+	case "png":
+		var stripped bool
+		if data, stripped = stripPNGExif(data); stripped {
+			notes = append(notes, "removed EXIF/XMP metadata")
+		}
+	}
+
+	img, _, err := decodeImage(data)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	changed := false
+	if orientation > 1 && orientation <= 8 {
+		img = orient(img, orientation)
+		notes = append(notes, "rotated upright")
+		changed = true
+	}
+	if b := img.Bounds(); b.Dx() > config.maxPhotoPixels || b.Dy() > config.maxPhotoPixels {
+		img = fitImage(img, config.maxPhotoPixels)
+		notes = append(notes, fmt.Sprintf("resized from %dx%d to %dx%d",
+			b.Dx(), b.Dy(), img.Bounds().Dx(), img.Bounds().Dy()))
+		changed = true
+	}
+	if !changed && len(data) <= config.maxPhotoBytes {
+		return data, filename, notes, nil
+	}
+
+	if format == "png" {
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, img); err != nil {
+			return nil, "", nil, err
+		}
+		if buf.Len() <= config.maxPhotoBytes {
+			return buf.Bytes(), filename, notes, nil
+		}
+		// Too big as PNG; flatten it for JPEG.
+		flat := image.NewRGBA(img.Bounds())
+		draw.Draw(flat, flat.Bounds(), image.White, image.Point{}, draw.Src)
+		draw.Draw(flat, flat.Bounds(), img, img.Bounds().Min, draw.Over)
+		img = flat
+		notes = append(notes, "converted to JPEG")
+		filename = strings.TrimSuffix(filename, path.Ext(filename)) + ".jpg"
+	}
+	out, err := compressJPEG(img, config.maxPhotoBytes)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	if len(out) < len(data) {
+		notes = append(notes, fmt.Sprintf("recompressed from %d KB to %d KB", len(data)/1024, len(out)/1024))
+	}
+	return out, filename, notes, nil
+}
+
+// compressJPEG encodes img at the best quality that fits in maxBytes,
+// scaling it down further when lowering the quality isn't enough.
+func compressJPEG(img image.Image, maxBytes int) ([]byte, error) {
+	var buf bytes.Buffer
+	for {
+		for quality := 90; quality >= 45; quality -= 15 {
+			buf.Reset()
+			if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality}); err != nil {
+				return nil, err
+			}
+			if buf.Len() <= maxBytes {
+				return buf.Bytes(), nil
+			}
+		}
+		b := img.Bounds()
+		if b.Dx() < 200 || b.Dy() < 200 {
+			return buf.Bytes(), nil
+		}
+		img = fitImage(img, maxInt(b.Dx(), b.Dy())*3/4)
+	}
+}
+
+// fitImage scales img down so neither side is longer than max.
+func fitImage(img image.Image, max int) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w >= h {
+		w, h = max, maxInt(1, h*max/w)
+	} else {
+		w, h = maxInt(1, w*max/h), max
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	scaleImage(dst, dst.Bounds(), img, b)
+	return dst
+}
+
+// orient applies an EXIF orientation to img so it displays upright.
+func orient(img image.Image, orientation int) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		for x := 0; x < dw; x++ {
+			var sx, sy int
+			switch orientation {
+			case 2:
+				sx, sy = w-1-x, y
+			case 3:
+				sx, sy = w-1-x, h-1-y
+			case 4:
+				sx, sy = x, h-1-y
+			case 5:
+				sx, sy = y, x
+			case 6:
+				sx, sy = y, h-1-x
+			case 7:
+				sx, sy = w-1-y, h-1-x
+			case 8:
+				sx, sy = w-1-y, x
+			}
+			dst.Set(x, y, img.At(b.Min.X+sx, b.Min.Y+sy))
+		}
+	}
+	return dst
+}
+
+// xmpPrefixes start the APP1 segments holding XMP, which often repeats
+// the GPS tags of the EXIF data.
+var xmpPrefixes = [][]byte{
+	[]byte("http://ns.adobe.com/xap/1.0/\x00"),
+	[]byte("http://ns.adobe.com/xmp/extension/\x00"),
+}
+
+func isXMP(segment []byte) bool {
+	for _, prefix := range xmpPrefixes {
+		if bytes.HasPrefix(segment, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// stripJPEGExif removes the EXIF and XMP segments of a JPEG without
+// re-encoding it, returning the orientation they recorded.
+func stripJPEGExif(data []byte) ([]byte, int, bool) {
+	orientation := 1
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return data, orientation, false
+	}
+	out := []byte{0xFF, 0xD8}
+	stripped := false
+	i := 2
+	for i+4 <= len(data) {
+		if data[i] != 0xFF {
+			break
+		}
+		marker := data[i+1]
+		// Entropy-coded data follows the start of scan.
+		if marker == 0xDA {
+			break
+		}
+		n := int(binary.BigEndian.Uint16(data[i+2:]))
+		end := i + 2 + n
+		if n < 2 || end > len(data) {
+			break
+		}
+		segment := data[i+4 : end]
+		if marker == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			if o := exifOrientation(segment[6:]); o != 0 {
+				orientation = o
+			}
+			stripped = true
+		} else if marker == 0xE1 && isXMP(segment) {
+			stripped = true
+		} else {
+			out = append(out, data[i:end]...)
+		}
+		i = end
+	}
+	if !stripped {
+		return data, orientation, false
+	}
+	return append(out, data[i:]...), orientation, true
+}
+
+// exifOrientation reads the orientation tag of IFD0 from TIFF data, or
+// returns 0.
+func exifOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 0
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 0
+	}
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd+2 > len(tiff) {
+		return 0
+	}
+	count := int(order.Uint16(tiff[ifd:]))
+	for i := 0; i < count; i++ {
+		entry := ifd + 2 + i*12
+		if entry+12 > len(tiff) {
+			return 0
+		}
+		if order.Uint16(tiff[entry:]) == 0x0112 {
+			return int(order.Uint16(tiff[entry+8:]))
+		}
+	}
+	return 0
+}
+
+// pngXMPKeyword names the text chunks holding XMP.
+const pngXMPKeyword = "XML:com.adobe.xmp\x00"
+
+// stripPNGExif drops the eXIf chunk and the XMP text chunks of a PNG.
+func stripPNGExif(data []byte) ([]byte, bool) {
+	const sig = "\x89PNG\r\n\x1a\n"
+	if !bytes.HasPrefix(data, []byte(sig)) {
+		return data, false
+	}
+	out := []byte(sig)
+	stripped := false
+	for i := len(sig); i+8 <= len(data); {
+		end := i + 12 + int(binary.BigEndian.Uint32(data[i:]))
+		if end > len(data) || end < i {
+			return data, false
+		}
+		chunk, body := string(data[i+4:i+8]), data[i+8:end-4]
+		if chunk == "eXIf" || (chunk == "iTXt" || chunk == "tEXt" || chunk == "zTXt") && bytes.HasPrefix(body, []byte(pngXMPKeyword)) {
+			stripped = true
+		} else {
+			out = append(out, data[i:end]...)
+		}
+		i = end
+	}
+	if !stripped {
+		return data, false
+	}
+	return out, true
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+// tiffWithOrientation returns TIFF data whose IFD0 has only the
+// orientation tag.
+func tiffWithOrientation(bigEndian bool, orientation byte) []byte {
+	if bigEndian {
+		return []byte{
+			'M', 'M', 0, 42, 0, 0, 0, 8,
+			0, 1,
+			0x01, 0x12, 0, 3, 0, 0, 0, 1, 0, orientation, 0, 0,
+			0, 0, 0, 0,
+		}
+	}
+	return []byte{
+		'I', 'I', 42, 0, 8, 0, 0, 0,
+		1, 0,
+		0x12, 0x01, 3, 0, 1, 0, 0, 0, orientation, 0, 0, 0,
+		0, 0, 0, 0,
+	}
+}
+
+func TestExifOrientation(t *testing.T) {
+	tests := []struct {
+		name string
+		tiff []byte
+		want int
+	}{
+		{"little endian", tiffWithOrientation(false, 6), 6},
+		{"big endian", tiffWithOrientation(true, 8), 8},
+		{"no orientation tag", []byte{'I', 'I', 42, 0, 8, 0, 0, 0, 1, 0, 0x10, 0x01, 2, 0, 1, 0, 0, 0, 0, 0, 0, 0}, 0},
+		{"bad byte order", append([]byte("XX"), tiffWithOrientation(false, 6)[2:]...), 0},
+		{"truncated entry", tiffWithOrientation(false, 6)[:14], 0},
+		{"IFD out of range", []byte{'I', 'I', 42, 0, 0xFF, 0, 0, 0}, 0},
+		{"too short", []byte("II"), 0},
+	}
+	for _, tt := range tests {
+		if got := exifOrientation(tt.tiff); got != tt.want {
+			t.Errorf("%s: exifOrientation = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestStripJPEGExif(t *testing.T) {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, image.NewGray(image.Rect(0, 0, 8, 8)), nil); err != nil {
+		t.Fatal(err)
+	}
+	plain := buf.Bytes()
+	exif := append([]byte("Exif\x00\x00"), tiffWithOrientation(false, 6)...)
+	app1 := append([]byte{0xFF, 0xE1, 0, byte(len(exif) + 2)}, exif...)
+	withExif := append(append(append([]byte{}, plain[:2]...), app1...), plain[2:]...)
+	xmp := []byte("http://ns.adobe.com/xap/1.0/\x00<x:xmpmeta exif:GPSLatitude=\"31,14N\"/>")
+	xmpApp1 := append([]byte{0xFF, 0xE1, 0, byte(len(xmp) + 2)}, xmp...)
+	withXMP := append(append(append([]byte{}, withExif[:2]...), xmpApp1...), withExif[2:]...)
+
+	tests := []struct {
+		name            string
+		data            []byte
+		want            []byte
+		wantOrientation int
+		wantStripped    bool
+	}{
+		{"exif", withExif, plain, 6, true},
+		{"exif and xmp", withXMP, plain, 6, true},
+		{"no exif", plain, plain, 1, false},
+		{"not a JPEG", []byte("GIF89a"), []byte("GIF89a"), 1, false},
+		{"truncated segment", withExif[:10], withExif[:10], 1, false},
+	}
+	for _, tt := range tests {
+		got, orientation, stripped := stripJPEGExif(tt.data)
+		if !bytes.Equal(got, tt.want) || orientation != tt.wantOrientation || stripped != tt.wantStripped {
+			t.Errorf("%s: stripJPEGExif = %d bytes, %d, %v; want %d bytes, %d, %v",
+				tt.name, len(got), orientation, stripped, len(tt.want), tt.wantOrientation, tt.wantStripped)
+		}
+	}
+}
+
+func TestStripPNGExif(t *testing.T) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, 8, 8))); err != nil {
+		t.Fatal(err)
+	}
+	plain := buf.Bytes()
+	chunk := func(typ, data string) []byte {
+		b := make([]byte, 4, 12+len(data))
+		binary.BigEndian.PutUint32(b, uint32(len(data)))
+		b = append(b, typ+data...)
+		return append(b, 0, 0, 0, 0)
+	}
+	// Metadata chunks go right after IHDR, which is 25 bytes.
+	insert := func(chunks ...[]byte) []byte {
+		b := append([]byte{}, plain[:33]...)
+		for _, c := range chunks {
+			b = append(b, c...)
+		}
+		return append(b, plain[33:]...)
+	}
+	comment := chunk("tEXt", "Comment\x00hello")
+	withComment := insert(comment)
+
+	tests := []struct {
+		name         string
+		data         []byte
+		want         []byte
+		wantStripped bool
+	}{
+		{"exif", insert(chunk("eXIf", "MM\x00*")), plain, true},
+		{"xmp iTXt", insert(chunk("iTXt", "XML:com.adobe.xmp\x00\x00\x00\x00\x00<x:xmpmeta/>")), plain, true},
+		{"xmp tEXt", insert(chunk("tEXt", "XML:com.adobe.xmp\x00<x:xmpmeta/>"), comment), withComment, true},
+		{"other text", withComment, withComment, false},
+		{"not a PNG", []byte("GIF89a"), []byte("GIF89a"), false},
+	}
+	for _, tt := range tests {
+		got, stripped := stripPNGExif(tt.data)
+		if !bytes.Equal(got, tt.want) || stripped != tt.wantStripped {
+			t.Errorf("%s: stripPNGExif = %d bytes, %v; want %d bytes, %v", tt.name, len(got), stripped, len(tt.want), tt.wantStripped)
+		}
+	}
+}
+
+func TestOrient(t *testing.T) {
+	red := color.RGBA{R: 0xFF, A: 0xFF}
+	blue := color.RGBA{B: 0xFF, A: 0xFF}
+	// A 3x2 image, red at its top left and blue at its top right.
+	src := image.NewRGBA(image.Rect(0, 0, 3, 2))
+	src.Set(0, 0, red)
+	src.Set(2, 0, blue)
+
+	tests := []struct {
+		orientation int
+		size        image.Point
+		red, blue   image.Point
+	}{
+		{2, image.Pt(3, 2), image.Pt(2, 0), image.Pt(0, 0)},
+		{3, image.Pt(3, 2), image.Pt(2, 1), image.Pt(0, 1)},
+		{4, image.Pt(3, 2), image.Pt(0, 1), image.Pt(2, 1)},
+		{5, image.Pt(2, 3), image.Pt(0, 0), image.Pt(0, 2)},
+		{6, image.Pt(2, 3), image.Pt(1, 0), image.Pt(1, 2)},
+		{7, image.Pt(2, 3), image.Pt(1, 2), image.Pt(1, 0)},
+		{8, image.Pt(2, 3), image.Pt(0, 2), image.Pt(0, 0)},
+	}
+	for _, tt := range tests {
+		dst := orient(src, tt.orientation)
+		if got := dst.Bounds().Size(); got != tt.size {
+			t.Errorf("orientation %d: size %v, want %v", tt.orientation, got, tt.size)
+			continue
+		}
+		if got := color.RGBAModel.Convert(dst.At(tt.red.X, tt.red.Y)); got != red {
+			t.Errorf("orientation %d: %v at %v, want red", tt.orientation, got, tt.red)
+		}
+		if got := color.RGBAModel.Convert(dst.At(tt.blue.X, tt.blue.Y)); got != blue {
+			t.Errorf("orientation %d: %v at %v, want blue", tt.orientation, got, tt.blue)
+		}
+	}
+}
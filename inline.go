@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pyzh/fanfou-telegram-bot/fanfou"
+	tb "gopkg.in/tucnak/telebot.v2"
+)
+
+const (
+	// inlinePageSize is how many statuses one page of inline results
+	// holds.
+	inlinePageSize = 20
+	// inlineCacheTTL is how long search results are reused.
+	inlineCacheTTL = 2 * time.Minute
+	// inlineMinePrefix searches the user's own statuses.
+	inlineMinePrefix = "me "
+	// inlinePostPrefix composes a status instead of searching.
+	inlinePostPrefix = "post:"
+	inlinePostResult = "post"
+)
+
+type inlineCacheEntry struct {
+	statuses []fanfou.Status
+	more     bool
+	expires  time.Time
+}
+
+var (
+	inlineCacheMu sync.Mutex
+	inlineCache   = make(map[string]inlineCacheEntry)
+)
+
+// handleQuery answers "@bot <keywords>" with matching public statuses,
+// or with the user's own when the query starts with "me ". The offset is
+// the ID of the last status shown. "@bot post: <text>" composes a status
+// instead.
+func handleQuery(q *tb.Query) {
+	ctx := context.Background()
+	info, err := activeAccount(ctx, q.From.ID)
+	if err != nil {
+		bot.Answer(q, &tb.QueryResponse{
+			IsPersonal:        true,
+			SwitchPMText:      "Link your Fanfou account to search",
+			SwitchPMParameter: "inline",
+		})
+		return
+	}
+	text := strings.TrimSpace(q.Text)
+	if strings.HasPrefix(text, inlinePostPrefix) {
+		compose := strings.TrimSpace(strings.TrimPrefix(text, inlinePostPrefix))
+		if compose == "" {
+			bot.Answer(q, &tb.QueryResponse{IsPersonal: true, CacheTime: 1})
+			return
+		}
+		resp := &tb.QueryResponse{IsPersonal: true, CacheTime: 1, Results: tb.Results{composeResult(info, compose)}}
+		if err := bot.Answer(q, resp); err != nil {
+			log.Println("answer query error ", err)
+		}
+		return
+	}
+	if text == "" {
+		bot.Answer(q, &tb.QueryResponse{IsPersonal: true, CacheTime: 1})
+		return
+	}
+	statuses, more, err := searchStatuses(info, text, q.Offset)
+	if err != nil {
+		log.Println("search statuses error ", err)
+		bot.Answer(q, &tb.QueryResponse{IsPersonal: true, CacheTime: 1})
+		return
+	}
+
+	resp := &tb.QueryResponse{IsPersonal: true, CacheTime: int(inlineCacheTTL.Seconds())}
+	for i := range statuses {
+		resp.Results = append(resp.Results, statusResult(&statuses[i]))
+	}
+	if more {
+		resp.NextOffset = statuses[len(statuses)-1].ID
+	}
+	if err := bot.Answer(q, resp); err != nil {
+		log.Println("answer query error ", err)
+	}
+}
+
+// searchStatuses returns a page of statuses for query older than maxID
+// and whether there are more, reusing recent results of the same search.
+func searchStatuses(info *oauthInfo, query, maxID string) ([]fanfou.Status, bool, error) {
+	key := info.FanfouID + "\x00" + query + "\x00" + maxID
+	inlineCacheMu.Lock()
+	entry, ok := inlineCache[key]
+	inlineCacheMu.Unlock()
+	if ok && time.Now().Before(entry.expires) {
+		return entry.statuses, entry.more, nil
+	}
+
+	// One extra status tells whether another page follows; max_id repeats
+	// the last status of the previous page, so ask for that one too.
+	count := inlinePageSize + 1
+	if maxID != "" {
+		count++
+	}
+	params := &fanfou.SearchParams{Query: query, MaxID: maxID, Count: count}
+	var statuses []fanfou.Status
+	var err error
+	if strings.HasPrefix(query, inlineMinePrefix) {
+		params.Query = strings.TrimSpace(strings.TrimPrefix(query, inlineMinePrefix))
+		statuses, err = clientFor(info).SearchUserTimeline(params)
+	} else {
+		statuses, err = clientFor(info).SearchPublicTimeline(params)
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	// max_id is inclusive; the status it names ended the last page.
+	if len(statuses) > 0 && statuses[0].ID == maxID {
+		statuses = statuses[1:]
+	}
+	more := len(statuses) > inlinePageSize
+	if more {
+		statuses = statuses[:inlinePageSize]
+	}
+
+	now := time.Now()
+	inlineCacheMu.Lock()
+	for k, e := range inlineCache {
+		if now.After(e.expires) {
+			delete(inlineCache, k)
+		}
+	}
+	inlineCache[key] = inlineCacheEntry{statuses: statuses, more: more, expires: now.Add(inlineCacheTTL)}
+	inlineCacheMu.Unlock()
+	return statuses, more, nil
+}
+
+// statusResult is an inline result that sends s formatted like the
+// statuses the bot delivers.
+func statusResult(s *fanfou.Status) tb.Result {
+	text := html.UnescapeString(s.Text)
+	r := &tb.ArticleResult{
+		Title:   text,
+		URL:     fanfou.StatusURL(s.ID),
+		HideURL: true,
+	}
+	if t, err := s.Time(); err == nil {
+		r.Description = t.Format("2006-01-02 15:04")
+	}
+	if s.User != nil {
+		r.Title = s.User.ScreenName
+		r.Description = text
+		r.ThumbURL = s.User.ProfileImageURL
+	}
+	if s.Photo != nil && s.Photo.ThumbURL != "" {
+		r.ThumbURL = s.Photo.ThumbURL
+	}
+	r.ID = s.ID
+	var content tb.InputMessageContent = &tb.InputTextMessageContent{Text: statusHTML(s), ParseMode: string(tb.ModeHTML)}
+	r.Content = &content
+	return r
+}
+
+// composeResult previews posting text as info. Picking it posts the
+// status, see handleChosenResult.
+func composeResult(info *oauthInfo, text string) tb.Result {
+	n := statusLength(text)
+	r := &tb.ArticleResult{
+		Title:       "Post to Fanfou as " + info.ScreenName,
+		Description: fmt.Sprintf("%d/%d characters: %s", n, statusLimit, text),
+	}
+	r.ID = inlinePostResult
+	content := "⏳ Posting to Fanfou: " + html.EscapeString(text)
+	if n > statusLimit {
+		// Nothing is posted; the message only says why.
+		r.ID = "too_long"
+		r.Title = fmt.Sprintf("Too long for Fanfou by %d characters", n-statusLimit)
+		content = html.EscapeString(text)
+	}
+	var c tb.InputMessageContent = &tb.InputTextMessageContent{Text: content, ParseMode: string(tb.ModeHTML)}
+	r.Content = &c
+	// Telegram only reports the inline message of results with a
+	// keyboard, and it is needed to edit in the status link.
+	r.ReplyMarkup = &tb.InlineKeyboardMarkup{InlineKeyboard: [][]tb.InlineButton{{
+		{Text: "@" + info.FanfouID, URL: fanfou.UserURL(info.FanfouID)},
+	}}}
+	return r
+}
+
+// handleChosenResult posts a status composed inline and puts its link in
+// the inline message. Telegram only sends chosen results when inline
+// feedback is enabled with BotFather.
+func handleChosenResult(r *tb.ChosenInlineResult) {
+	if r.ResultID != inlinePostResult {
+		return
+	}
+	ctx := context.Background()
+	msg := tb.StoredMessage{MessageID: r.MessageID}
+	text := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(r.Query), inlinePostPrefix))
+	info, err := activeAccount(ctx, r.From.ID)
+	if err != nil {
+		bot.Edit(msg, "❌ Not posted: no Fanfou account is linked.")
+		return
+	}
+	status, err := clientFor(info).UpdateStatus(&fanfou.StatusParams{Status: text})
+	if err != nil {
+		log.Println("call statuses update error ", err)
+		reason := "please try again"
+		if apiErr, ok := err.(*fanfou.Error); ok {
+			reason = apiErr.Message
+		}
+		bot.Edit(msg, "❌ Not posted, "+reason+": "+text)
+		return
+	}
+	bot.Edit(msg, statusHTML(status), tb.ModeHTML)
+	sendReceipt(ctx, r.From.ID, info, status)
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	tb "gopkg.in/tucnak/telebot.v2"
+)
+
+const lastLocationKind = "last_locations"
+
+// savedLocation is a location waiting to be attached to the next post.
+type savedLocation struct {
+	Location string
+}
+
+// fanfouLocation formats l the way the location parameter of Fanfou
+// takes coordinates.
+func fanfouLocation(l tb.Location) string {
+	return fmt.Sprintf("%.6f,%.6f", l.Lat, l.Lng)
+}
+
+// handleLocation posts a shared location or venue as a geotagged
+// status, or saves it for the next post when the user asked for that.
+// Telegram sets Location on venue messages too, so both arrive here.
+func handleLocation(m *tb.Message) {
+	ctx := context.Background()
+	var l tb.Location
+	text := "📍 I'm here"
+	if m.Venue != nil {
+		l = m.Venue.Location
+		text = "📍 " + strings.TrimSuffix(m.Venue.Title+", "+m.Venue.Address, ", ")
+	} else if m.Location != nil {
+		l = *m.Location
+	}
+	settings, err := getSettings(ctx, m.Sender.ID)
+	if err != nil {
+		log.Println("get settings error ", err)
+		return
+	}
+	if settings.AttachLocation {
+		err := recordStore.PutRecord(ctx, lastLocationKind, userKey(m.Sender.ID), &savedLocation{fanfouLocation(l)})
+		if err != nil {
+			log.Println("save location error ", err)
+			return
+		}
+		bot.Send(m.Sender, "Location saved, it will be attached to your next post.")
+		return
+	}
+
+	infos, err := postingAccounts(ctx, m.Sender.ID)
+	if err != nil {
+		log.Println("get key error ", err)
+		return
+	}
+	targets := make([]postedStatus, len(infos))
+	for i, info := range infos {
+		targets[i] = postedStatus{Account: info.FanfouID, Location: fanfouLocation(l)}
+	}
+	postStatuses(ctx, m, text, targets)
+}
+
+// takeLocation returns the location saved for the next post of
+// telegramID, forgetting it, or "" when there is none.
+func takeLocation(ctx context.Context, telegramID int) string {
+	l := &savedLocation{}
+	if err := recordStore.TakeRecord(ctx, lastLocationKind, userKey(telegramID), l); err != nil {
+		if err != errNoRecord {
+			log.Println("get location error ", err)
+		}
+		return ""
+	}
+	return l.Location
+}
+
+func handleLocationMode(m *tb.Message) {
+	ctx := context.Background()
+	var attach bool
+	switch strings.TrimSpace(m.Payload) {
+	case "post":
+	case "attach":
+		attach = true
+	default:
+		bot.Send(m.Sender, "Usage: /location post|attach. Shared locations are posted as statuses, or saved and attached to your next post.")
+		return
+	}
+	err := updateSettings(ctx, m.Sender.ID, func(s *userSettings) {
+		s.AttachLocation = attach
+	})
+	if err != nil {
+		log.Println("save settings error ", err)
+		return
+	}
+	if attach {
+		bot.Send(m.Sender, "Send a location and it will be attached to your next post.")
+		return
+	}
+	if err := recordStore.DeleteRecord(ctx, lastLocationKind, userKey(m.Sender.ID)); err != nil {
+		log.Println("delete location error ", err)
+	}
+	bot.Send(m.Sender, "Shared locations will be posted as statuses.")
+}
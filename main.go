@@ -1,62 +1,88 @@
 package main
 
 import (
-	"bytes"
 	"context"
-	"encoding/json"
+	"flag"
 	"fmt"
-	"io/ioutil"
 	"log"
-	"mime/multipart"
 	"net/http"
-	"net/url"
 	"os"
-	"strconv"
-	"strings"
 	"time"
 
-	"cloud.google.com/go/datastore"
 	"github.com/dghubble/oauth1"
 	"github.com/go-chi/chi"
 	"github.com/go-chi/chi/middleware"
+	"github.com/pyzh/fanfou-telegram-bot/fanfou"
 	tb "gopkg.in/tucnak/telebot.v2"
 )
 
-var oauthConfig = oauth1.Config{
-	ConsumerKey:            os.Getenv("ConsumerKey"),
-	ConsumerSecret:         os.Getenv("ConsumerSecret"),
-	CallbackURL:            "https://fanfou-204818.appspot.com/callback",
-	Endpoint:               FanfouEndpoint,
-	DisableCallbackConfirm: true,
-}
+var config *Config
+
+var oauthConfig oauth1.Config
+
+var bot *tb.Bot
 
-var datastoreClient *datastore.Client
+var tokenStore TokenStore
+
+var recordStore RecordStore
 
 type oauthInfo struct {
 	Token  string
 	Secret string
+	// KeyID names the key DataKey is sealed with, if Token and Secret
+	// are encrypted.
+	KeyID string
+	// DataKey encrypts Token and Secret.
+	DataKey string `datastore:",noindex"`
+
+	FanfouID   string
+	ScreenName string
 }
 
-type responseError struct {
-	Request string `json:"request"`
-	Error   string `json:"error"`
-}
+func main() {
+	configPath := flag.String("config", os.Getenv("ConfigFile"), "optional YAML or TOML config file")
+	flag.Parse()
 
-type updateResponse struct {
-	ID string `json:"id"`
-}
+	var err error
+	config, err = loadConfig(*configPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	oauthConfig = oauth1.Config{
+		ConsumerKey:            config.ConsumerKey,
+		ConsumerSecret:         config.ConsumerSecret,
+		CallbackURL:            config.CallbackURL,
+		Endpoint:               fanfou.OAuthEndpoint(config.FanfouOAuthURL),
+		DisableCallbackConfirm: true,
+	}
 
-func main() {
 	ctx := context.Background()
-	projectID := os.Getenv("ProjectID")
-	datastoreClient, err := datastore.NewClient(ctx, projectID)
+	store, err := newStore(ctx, config.TokenStore, config.ProjectID, config.TokenStorePath)
 	if err != nil {
 		log.Fatal(err)
 	}
+	tokenStore, recordStore = store, store
+	if config.TokenKeys != "" {
+		tokenStore, err = newEncryptedTokenStore(store, config.TokenKeys)
+		if err != nil {
+			log.Fatal(err)
+		}
+	} else {
+		log.Println("TokenKeys is not set, access tokens are stored unencrypted")
+	}
+	go expireAuthStates(ctx, 10*time.Minute)
+
+	var poller tb.Poller = &longPoller{Timeout: 10 * time.Second}
+	var webhook *webhookPoller
+	if config.PollerMode == "webhook" {
+		webhook = newWebhookPoller(config.PublicURL, config.WebhookSecret)
+		poller = webhook
+	}
 
-	bot, err := tb.NewBot(tb.Settings{
-		Token:  os.Getenv("TelegramToken"),
-		Poller: &tb.LongPoller{Timeout: 10 * time.Second},
+	bot, err = tb.NewBot(tb.Settings{
+		Token:  config.TelegramToken,
+		Poller: poller,
+		Client: config.telegramClient(),
 	})
 
 	if err != nil {
@@ -66,7 +92,7 @@ func main() {
 
 	bot.Handle("/start", func(m *tb.Message) {
 		log.Println("handle /start")
-		authorizationURL, err := getAuthorizationURL(m.Sender.ID)
+		authorizationURL, err := getAuthorizationURL(ctx, m.Sender.ID)
 		if err != nil {
 			bot.Send(m.Sender, err.Error())
 		} else {
@@ -75,125 +101,88 @@ func main() {
 		}
 	})
 
-	bot.Handle(tb.OnText, func(m *tb.Message) {
-		k := getKey(m.Sender.ID)
-		info := &oauthInfo{}
-		err := datastoreClient.Get(ctx, k, info)
-		if err != nil {
-			log.Println("get key error ", err)
-			return
-		}
-		token := oauth1.NewToken(info.Token, info.Secret)
-		httpClient := oauthConfig.Client(oauth1.NoContext, token)
-		data := url.Values{}
-		data.Set("status", m.Text)
-		resp, err := httpClient.Post("http://api.fanfou.com/statuses/update.json", "application/x-www-form-urlencoded", strings.NewReader(data.Encode()))
-		if err != nil {
-			log.Println("call statuses update error ", err)
-			return
-		}
-		defer resp.Body.Close()
-		body, _ := ioutil.ReadAll(resp.Body)
-		if resp.StatusCode != 200 {
-			respErr := responseError{}
-			if err = json.Unmarshal(body, &respErr); err != nil {
-				log.Println("Unmarshal error ", err)
-				return
-			}
-			log.Println("call fanfou statuses api error ", respErr.Error)
-			bot.Send(m.Sender, respErr.Error)
-			return
-		}
-		response := updateResponse{}
-		if err = json.Unmarshal(body, &response); err != nil {
-			log.Println("Unmarshal error", err)
-			return
-		}
-		bot.Send(m.Sender, "https://fanfou.com/statuses/"+response.ID)
-	})
-
-	// https://api.fanfou.com/photos/upload.json
-	bot.Handle(tb.OnPhoto, func(m *tb.Message) {
-		log.Println("handle photo")
-		caption := "Just posted a photo"
-		if m.Caption != "" {
-			caption = m.Caption
-		}
-		f, err := bot.FileByID(m.Photo.FileID)
-		if err != nil {
-			log.Println("get file error: ", err)
-			return
-		}
-		url := "https://api.telegram.org/file/bot" + bot.Token + "/" + f.FilePath
-		telegramResp, err := http.Get(url)
-		if err != nil {
-			log.Println("get file error ", err)
-			return
-		}
-		defer telegramResp.Body.Close()
-		fileContents, err := ioutil.ReadAll(telegramResp.Body)
-		if err != nil {
-			log.Println("read file error ", err)
-			return
-		}
-
-		bodyBuf := new(bytes.Buffer)
-		w := multipart.NewWriter(bodyBuf)
-		status, err := w.CreateFormField("status")
-		if err != nil {
-			log.Println("status field error ", err)
-			return
-		}
-		// Write status field
-		status.Write([]byte(caption))
-
-		photo, err := w.CreateFormFile("photo", f.FilePath)
-		if err != nil {
-			log.Println("photo field error ", err)
-			return
+	bot.Handle("/logout", handleLogout)
+	bot.Handle(&logoutConfirmBtn, handleLogoutConfirm)
+	bot.Handle("/deletemydata", handleDeleteMyData)
+	bot.Handle(&deleteDataConfirmBtn, handleDeleteMyDataConfirm)
+	bot.Handle(&cancelBtn, handleCancel)
+
+	bot.Handle("/accounts", handleAccounts)
+	bot.Handle(&switchAccountBtn, handleSwitchAccount)
+	bot.Handle(&fanoutAccountBtn, handleFanoutAccount)
+	bot.Handle(&removeAccountBtn, handleRemoveAccount)
+
+	bot.Handle("/subscribe", handleSubscribe)
+	bot.Handle("/unsubscribe", handleUnsubscribe)
+	bot.Handle("/pause", handlePause)
+	bot.Handle("/resume", handleResume)
+	bot.Handle("/interval", handleInterval)
+
+	bot.Handle("/mentions", handleMentions)
+	bot.Handle(&mentionReplyBtn, handleMentionReply)
+	bot.Handle(&mentionFavoriteBtn, handleMentionFavorite)
+
+	bot.Handle("/dm", handleDM)
+	bot.Handle("/inbox", handleInbox)
+	bot.Handle(&inboxPrevBtn, handleInboxPage)
+	bot.Handle(&inboxNextBtn, handleInboxPage)
+
+	bot.Handle(&receiptDeleteBtn, handleReceiptDelete)
+	bot.Handle(&receiptUndoBtn, handleReceiptUndo)
+
+	bot.Handle(&splitPostBtn, handleSplitPost)
+	bot.Handle(&splitNumberBtn, handleSplitNumber)
+	bot.Handle(&splitCancelBtn, handleSplitCancel)
+
+	bot.Handle("/edits", handleEdits)
+	bot.Handle("/albums", handleAlbums)
+	bot.Handle("/location", handleLocationMode)
+	bot.Handle("/forwards", handleForwards)
+	bot.Handle("/channel", handleChannel)
+	bot.Handle("/ff", handleFF)
+	bot.Handle(tb.OnQuery, handleQuery)
+	bot.Handle(tb.OnChosenInlineResult, handleChosenResult)
+	bot.Handle(tb.OnAddedToGroup, handleAddedToGroup)
+	bot.Handle("/team", handleTeam)
+	bot.Handle("/propose", handlePropose)
+	bot.Handle(&teamApproveBtn, handleTeamApprove)
+	bot.Handle(&teamRejectBtn, handleTeamReject)
+	bot.Handle(tb.OnChannelPost, handleChannelPost)
+	bot.Handle(tb.OnEditedChannelPost, handleEditedChannelPost)
+	bot.Handle(&forwardAttributeBtn, handleForwardAttribute)
+	bot.Handle(&forwardStripBtn, handleForwardStrip)
+	bot.Handle(&forwardCancelBtn, handleForwardCancel)
+	bot.Handle(tb.OnText, handleText)
+	bot.Handle(tb.OnEdited, handleEdited)
+	bot.Handle(tb.OnPhoto, privateOnly(handlePhoto))
+	bot.Handle(tb.OnDocument, privateOnly(handleDocument))
+	bot.Handle(tb.OnSticker, privateOnly(handleSticker))
+	bot.Handle(tb.OnVideo, privateOnly(handleVideo))
+	bot.Handle(tb.OnVideoNote, privateOnly(handleVideoNote))
+	bot.Handle(tb.OnLocation, privateOnly(handleLocation))
+	bot.Handle(tb.OnVenue, privateOnly(handleLocation))
+	bot.Handle(tb.OnAudio, privateOnly(handleUnsupportedMedia))
+	bot.Handle(tb.OnVoice, privateOnly(handleUnsupportedMedia))
+
+	if webhook == nil {
+		// getUpdates is refused while a webhook is registered.
+		if err := callBotAPI(bot, "deleteWebhook", map[string]string{}); err != nil {
+			log.Println("delete webhook error ", err)
 		}
-		photo.Write(fileContents)
-
-		w.Close()
-
-		info := &oauthInfo{}
-		if err = datastoreClient.Get(ctx, getKey(m.Sender.ID), info); err != nil {
-			log.Println("get key error ", err)
-			return
-		}
-		token := oauth1.NewToken(info.Token, info.Secret)
-		httpClient := oauthConfig.Client(oauth1.NoContext, token)
-		respFanfou, err := httpClient.Post("http://api.fanfou.com/photos/upload.json", w.FormDataContentType(), bodyBuf)
-		if err != nil {
-			log.Println("send photo error ", err)
-			return
-		}
-		defer respFanfou.Body.Close()
-		body, _ := ioutil.ReadAll(respFanfou.Body)
-		if respFanfou.StatusCode != 200 {
-			respErr := responseError{}
-			if err = json.Unmarshal(body, &respErr); err != nil {
-				log.Println("Unmarshal error ", err)
-				return
-			}
-			log.Println("call fanfou photos api error ", respErr.Error)
-			bot.Send(m.Sender, respErr.Error)
-			return
-		}
-		response := updateResponse{}
-		if err = json.Unmarshal(body, &response); err != nil {
-			log.Println("Unmarshal error", err)
-			return
-		}
-		bot.Send(m.Sender, "https://fanfou.com/statuses/"+response.ID)
-	})
-
+	}
 	go bot.Start()
+	go runTimelinePush(ctx, time.Minute)
+	go mentionPoller.run(ctx, 2*time.Minute)
+	go dmPoller.run(ctx, 2*time.Minute)
 
 	r := chi.NewRouter()
 	r.Use(middleware.Logger)
 	r.Use(middleware.Recoverer)
 
+	if webhook != nil {
+		r.Post(webhook.Path(), webhook.ServeHTTP)
+	}
+
 	r.Get("/", func(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte("welcome"))
 	})
@@ -201,64 +190,14 @@ func main() {
 		w.Write([]byte("ok"))
 	})
 
-	// fanfou callback
-	// https://address/callback?telegram_id=1&oauth_token=e5be60f65bbd0d23b92d7abc705f3&request_secret=111
-	r.Get("/callback", func(w http.ResponseWriter, r *http.Request) {
-		requestSecret := r.URL.Query().Get("request_secret")
-		telegramIDParams := r.URL.Query().Get("telegram_id")
-		telegramID, err := strconv.Atoi(telegramIDParams)
-		if err != nil {
-			http.Error(w, err.Error(), 400)
-			return
-		}
-
-		requestToken, verifier, err := oauth1.ParseAuthorizationCallback(r)
-		if err != nil {
-			http.Error(w, err.Error(), 400)
-			return
-		}
-		accessToken, accessSecret, err := oauthConfig.AccessToken(requestToken, requestSecret, verifier)
-		if err != nil {
-			http.Error(w, err.Error(), 400)
-			return
-		}
-
-		ctx := context.Background()
-		k := getKey(telegramID)
-		v := &oauthInfo{Token: accessToken, Secret: accessSecret}
-		if _, err := datastoreClient.Put(ctx, k, v); err != nil {
-			http.Error(w, err.Error(), 500)
-			return
-		}
+	r.Get("/callback", handleCallback)
 
-		bot.Send(&tb.User{ID: telegramID}, "Success Authorization")
-		w.Write([]byte("it's ok"))
-	})
-
-	log.Fatal(http.ListenAndServe(":8080", r))
+	log.Fatal(http.ListenAndServe(config.ListenAddr, r))
 }
 
-func getAuthorizationURL(telegramID int) (url string, err error) {
-	requestToken, requestSecret, err := oauthConfig.RequestToken()
-	if err != nil {
-		return
-	}
-	authorizationURL, err := oauthConfig.AuthorizationURL(requestToken)
-	if err != nil {
-		return
-	}
-	q := authorizationURL.Query()
-	callback := fmt.Sprintf("%s?telegram_id=%d&request_secret=%s", oauthConfig.CallbackURL, telegramID, requestSecret)
-	q.Set("oauth_callback", callback)
-	authorizationURL.RawQuery = q.Encode()
-
-	if err != nil {
-		return
+// sendFanfouError relays Fanfou API error messages to the user.
+func sendFanfouError(bot *tb.Bot, to tb.Recipient, err error) {
+	if apiErr, ok := err.(*fanfou.Error); ok {
+		bot.Send(to, apiErr.Message)
 	}
-	return authorizationURL.String(), nil
-}
-
-func getKey(telegramID int) *datastore.Key {
-	return datastore.IDKey("fanfou_tokens", int64(telegramID), nil)
-
 }
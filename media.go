@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"image/png"
+	"log"
+	"path"
+	"strings"
+
+	"github.com/pyzh/fanfou-telegram-bot/fanfou"
+	tb "gopkg.in/tucnak/telebot.v2"
+
+	// Decoders for images sent as files and for stickers.
+	_ "golang.org/x/image/webp"
+	_ "image/gif"
+)
+
+const unsupportedMedia = "Sorry, Fanfou only takes photos. Send images, stickers, GIFs or videos and the bot will post a picture of them."
+
+// handleDocument posts images sent as files. GIFs keep their animation;
+// videos, including Telegram's converted GIFs, are posted as their
+// thumbnail.
+func handleDocument(m *tb.Message) {
+	d := m.Document
+	switch {
+	case d.MIME == "image/jpeg" || d.MIME == "image/png" || d.MIME == "image/gif":
+		data, _, err := downloadFile(d.FileID)
+		if err != nil {
+			log.Println("get file error ", err)
+			return
+		}
+		caption := "Just posted a photo"
+		if d.MIME == "image/gif" {
+			caption = "Just posted a GIF"
+		}
+		postPhoto(m, caption, d.FileName, data)
+	case d.MIME == "image/webp":
+		postConverted(m, "Just posted a photo", d.FileID, d.Thumbnail)
+	case strings.HasPrefix(d.MIME, "video/") && d.Thumbnail != nil:
+		postThumbnail(m, "Just posted a GIF", d.Thumbnail)
+	default:
+		bot.Send(m.Sender, unsupportedMedia)
+	}
+}
+
+func handleSticker(m *tb.Message) {
+	caption := "Just posted a sticker"
+	if m.Sticker.Emoji != "" {
+		caption = m.Sticker.Emoji
+	}
+	postConverted(m, caption, m.Sticker.FileID, m.Sticker.Thumbnail)
+}
+
+func handleVideo(m *tb.Message) {
+	if m.Video.Thumbnail == nil {
+		bot.Send(m.Sender, "Sorry, this video has no preview image to post.")
+		return
+	}
+	postThumbnail(m, "Just posted a video", m.Video.Thumbnail)
+}
+
+func handleVideoNote(m *tb.Message) {
+	if m.VideoNote.Thumbnail == nil {
+		bot.Send(m.Sender, "Sorry, this video message has no preview image to post.")
+		return
+	}
+	postThumbnail(m, "Just posted a video message", m.VideoNote.Thumbnail)
+}
+
+func handleUnsupportedMedia(m *tb.Message) {
+	bot.Send(m.Sender, unsupportedMedia)
+}
+
+// postConverted posts the image fileID as PNG. Animated and video
+// stickers can't be converted, so their thumbnail is posted instead.
+func postConverted(m *tb.Message, caption, fileID string, thumb *tb.Photo) {
+	data, filePath, err := downloadFile(fileID)
+	if err != nil {
+		log.Println("get file error ", err)
+		return
+	}
+	if ext := path.Ext(filePath); ext == ".tgs" || ext == ".webm" {
+		if thumb == nil {
+			bot.Send(m.Sender, "Sorry, animated stickers without a preview can't be posted to Fanfou.")
+			return
+		}
+		if data, _, err = downloadFile(thumb.FileID); err != nil {
+			log.Println("get file error ", err)
+			return
+		}
+	}
+	converted, err := toPNG(data)
+	if err != nil {
+		log.Println("convert image error ", err)
+		bot.Send(m.Sender, "Sorry, this image is in a format the bot can't convert for Fanfou. Try sending it as a photo.")
+		return
+	}
+	postPhoto(m, caption, "image.png", converted)
+}
+
+// postThumbnail posts the preview image Telegram made for a video.
+func postThumbnail(m *tb.Message, caption string, thumb *tb.Photo) {
+	data, filePath, err := downloadFile(thumb.FileID)
+	if err != nil {
+		log.Println("get file error ", err)
+		return
+	}
+	postPhoto(m, caption, path.Base(filePath), data)
+}
+
+func toPNG(data []byte) ([]byte, error) {
+	img, _, err := decodeImage(data)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// postPhoto uploads data as a photo to the posting accounts of the
+// sender of m. The caption of m, if any, replaces caption. Forwarded
+// photos are always credited unless the user strips forwards, as there
+// is nothing to ask about.
+func postPhoto(m *tb.Message, caption, filename string, data []byte) {
+	ctx := context.Background()
+	if m.Caption != "" {
+		caption = m.Caption
+	}
+	if isForward(m) {
+		if text, ok := forwardText(ctx, m, caption); ok {
+			caption = text
+		} else {
+			caption = attributeForward(m, caption)
+		}
+	}
+	data, filename, notes, err := preparePhoto(data, filename)
+	if err != nil {
+		log.Println("prepare photo error ", err)
+		bot.Send(m.Sender, "Sorry, this image could not be read.")
+		return
+	}
+	infos, err := postingAccounts(ctx, m.Sender.ID)
+	if err != nil {
+		log.Println("get key error ", err)
+		return
+	}
+	location := takeLocation(ctx, m.Sender.ID)
+	for _, info := range infos {
+		params := &fanfou.StatusParams{Status: caption, Location: location}
+		status, err := clientFor(info).UploadPhoto(params, filename, bytes.NewReader(data))
+		if err != nil {
+			log.Println("send photo error ", err)
+			sendFanfouError(bot, m.Sender, err)
+			continue
+		}
+		sendReceipt(ctx, m.Sender.ID, info, status, notes...)
+	}
+}
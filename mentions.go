@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/pyzh/fanfou-telegram-bot/fanfou"
+	tb "gopkg.in/tucnak/telebot.v2"
+)
+
+const mentionCursorKind = "mention_cursors"
+
+var (
+	mentionReplyBtn    = tb.InlineButton{Unique: "mention_reply", Text: "Reply"}
+	mentionFavoriteBtn = tb.InlineButton{Unique: "mention_favorite", Text: "Favorite"}
+)
+
+func handleMentions(m *tb.Message) {
+	ctx := context.Background()
+	info, err := activeAccount(ctx, m.Sender.ID)
+	if err == errNoToken {
+		bot.Send(m.Sender, "Link a Fanfou account with /start first.")
+		return
+	}
+	if err != nil {
+		log.Println("get key error ", err)
+		return
+	}
+	var disabled bool
+	switch strings.TrimSpace(m.Payload) {
+	case "on":
+	case "off":
+		disabled = true
+	default:
+		bot.Send(m.Sender, "Usage: /mentions on|off, for the active account.")
+		return
+	}
+	key := userKey(m.Sender.ID, info.FanfouID)
+	// Update in place so a poll running meanwhile keeps its progress.
+	cursor := &accountCursor{}
+	err = recordStore.UpdateRecord(ctx, mentionCursorKind, key, cursor, func() error {
+		cursor.Disabled = disabled
+		return nil
+	})
+	if err == errNoRecord {
+		err = recordStore.PutRecord(ctx, mentionCursorKind, key, &accountCursor{Disabled: disabled})
+	}
+	if err != nil {
+		log.Println("save mention cursor error ", err)
+		return
+	}
+	state := "on"
+	if disabled {
+		state = "off"
+	}
+	bot.Send(m.Sender, fmt.Sprintf("Mention notifications for %s are %s.", info.FanfouID, state))
+}
+
+// mentionPoller notifies users about new mentions of their accounts.
+var mentionPoller = &cursorPoller{kind: mentionCursorKind, fetch: fetchMentions}
+
+func fetchMentions(ctx context.Context, telegramID int, info *oauthInfo, sinceID string) ([]cursorItem, error) {
+	statuses, err := clientFor(info).Mentions(&fanfou.TimelineParams{SinceID: sinceID, Count: 20})
+	if err != nil {
+		return nil, err
+	}
+	items := make([]cursorItem, len(statuses))
+	for i := range statuses {
+		s := &statuses[i]
+		items[i] = cursorItem{ID: s.ID, deliver: func() error {
+			markup := &tb.ReplyMarkup{InlineKeyboard: [][]tb.InlineButton{{
+				mentionReplyBtn,
+				mentionFavoriteBtn,
+				{Text: "Open", URL: fanfou.StatusURL(s.ID)},
+			}}}
+			_, err := sendStatus(ctx, telegramID, info.FanfouID, s, markup)
+			return err
+		}}
+	}
+	return items, nil
+}
+
+func handleMentionReply(c *tb.Callback) {
+	ctx := context.Background()
+	ref, err := lookupStatusMessage(ctx, c.Sender.ID, c.Message)
+	if err != nil {
+		bot.Respond(c, &tb.CallbackResponse{Text: "This status is no longer known."})
+		return
+	}
+	bot.Respond(c)
+	prompt, err := bot.Send(c.Sender, "Reply to @"+ref.ScreenName+":", tb.ForceReply)
+	if err != nil {
+		log.Println("send reply prompt error ", err)
+		return
+	}
+	if err := rememberStatusMessage(ctx, c.Sender.ID, prompt, ref); err != nil {
+		log.Println("remember status message error ", err)
+	}
+}
+
+func handleMentionFavorite(c *tb.Callback) {
+	ctx := context.Background()
+	ref, err := lookupStatusMessage(ctx, c.Sender.ID, c.Message)
+	if err != nil {
+		bot.Respond(c, &tb.CallbackResponse{Text: "This status is no longer known."})
+		return
+	}
+	info, err := tokenStore.Get(ctx, c.Sender.ID, ref.Account)
+	if err != nil {
+		bot.Respond(c, &tb.CallbackResponse{Text: ref.Account + " is no longer linked."})
+		return
+	}
+	if _, err := clientFor(info).CreateFavorite(ref.StatusID); err != nil {
+		respondFanfouError(c, err)
+		return
+	}
+	bot.Respond(c, &tb.CallbackResponse{Text: "Favorited"})
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/dghubble/oauth1"
+	tb "gopkg.in/tucnak/telebot.v2"
+)
+
+const authStateKind = "oauth_states"
+
+// authStateTTL is how long an authorization link stays valid.
+const authStateTTL = 15 * time.Minute
+
+// authState is a pending authorization, kept server side and referenced
+// from the callback URL only by its random key.
+type authState struct {
+	RequestToken  string
+	RequestSecret string
+	TelegramID    int
+	Expires       time.Time
+}
+
+func getAuthorizationURL(ctx context.Context, telegramID int) (string, error) {
+	requestToken, requestSecret, err := oauthConfig.RequestToken()
+	if err != nil {
+		return "", err
+	}
+	authorizationURL, err := oauthConfig.AuthorizationURL(requestToken)
+	if err != nil {
+		return "", err
+	}
+	state, err := randomString(16)
+	if err != nil {
+		return "", err
+	}
+	pending := &authState{
+		RequestToken:  requestToken,
+		RequestSecret: requestSecret,
+		TelegramID:    telegramID,
+		Expires:       time.Now().Add(authStateTTL),
+	}
+	if err := recordStore.PutRecord(ctx, authStateKind, state, pending); err != nil {
+		return "", err
+	}
+
+	q := authorizationURL.Query()
+	q.Set("oauth_callback", oauthConfig.CallbackURL+"?state="+url.QueryEscape(state))
+	authorizationURL.RawQuery = q.Encode()
+	return authorizationURL.String(), nil
+}
+
+// handleCallback finishes the authorization started by /start.
+// https://address/callback?state=3f2a...&oauth_token=e5be60f65bbd0d23b92d7abc705f3
+func handleCallback(w http.ResponseWriter, r *http.Request) {
+	requestToken, verifier, err := oauth1.ParseAuthorizationCallback(r)
+	if err != nil {
+		renderPage(w, 400, "Invalid request", err.Error())
+		return
+	}
+
+	ctx := r.Context()
+	pending := &authState{}
+	err = recordStore.TakeRecord(ctx, authStateKind, r.URL.Query().Get("state"), pending)
+	if err == errNoRecord {
+		renderPage(w, 410, "Link already used", "This authorization link is unknown or has already been used. Send /start to the bot to get a new one.")
+		return
+	}
+	if err != nil {
+		renderPage(w, 500, "Something went wrong", err.Error())
+		return
+	}
+	if time.Now().After(pending.Expires) {
+		renderPage(w, 410, "Link expired", "This authorization link has expired. Send /start to the bot to get a new one.")
+		return
+	}
+	if pending.RequestToken != requestToken {
+		renderPage(w, 400, "Invalid request", "The request token does not match this authorization link.")
+		return
+	}
+
+	accessToken, accessSecret, err := oauthConfig.AccessToken(requestToken, pending.RequestSecret, verifier)
+	if err != nil {
+		renderPage(w, 400, "Authorization failed", err.Error())
+		return
+	}
+	info, err := linkAccount(ctx, pending.TelegramID, accessToken, accessSecret)
+	if err != nil {
+		renderPage(w, 500, "Something went wrong", err.Error())
+		return
+	}
+
+	text := fmt.Sprintf("Success Authorization: now posting as %s (%s). See /accounts to manage linked accounts.", info.ScreenName, info.FanfouID)
+	bot.Send(&tb.User{ID: pending.TelegramID}, text)
+	renderPage(w, 200, "It's ok", "Your Fanfou account is linked. You can go back to Telegram now.")
+}
+
+// expireAuthStates drops abandoned authorizations every interval.
+func expireAuthStates(ctx context.Context, interval time.Duration) {
+	for range time.Tick(interval) {
+		keys, err := recordStore.RecordKeys(ctx, authStateKind, "")
+		if err != nil {
+			log.Println("list auth states error ", err)
+			continue
+		}
+		for _, key := range keys {
+			pending := &authState{}
+			if err := recordStore.GetRecord(ctx, authStateKind, key, pending); err != nil {
+				continue
+			}
+			if time.Now().After(pending.Expires) {
+				recordStore.DeleteRecord(ctx, authStateKind, key)
+			}
+		}
+	}
+}
+
+var pageTemplate = template.Must(template.New("page").Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>{{.Title}}</title></head>
+<body><h1>{{.Title}}</h1><p>{{.Message}}</p></body></html>
+`))
+
+func renderPage(w http.ResponseWriter, status int, title, message string) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	pageTemplate.Execute(w, struct{ Title, Message string }{title, message})
+}
+
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	tb "gopkg.in/tucnak/telebot.v2"
+)
+
+// longPoller works like tb.LongPoller but decodes the updates itself, so
+// that fields the vendored telebot doesn't know about can be read too.
+type longPoller struct {
+	Timeout time.Duration
+
+	lastUpdateID int
+}
+
+func (p *longPoller) Poll(b *tb.Bot, dest chan tb.Update, stop chan struct{}) {
+	go func() {
+		<-stop
+		close(stop)
+	}()
+	for {
+		updates, err := p.getUpdates(b)
+		if err != nil {
+			log.Println("get updates error ", err)
+			time.Sleep(time.Second)
+			continue
+		}
+		for _, upd := range updates {
+			p.lastUpdateID = upd.ID
+			dest <- upd
+		}
+	}
+}
+
+func (p *longPoller) getUpdates(b *tb.Bot) ([]tb.Update, error) {
+	params := map[string]string{
+		"offset":  strconv.Itoa(p.lastUpdateID + 1),
+		"timeout": strconv.Itoa(int(p.Timeout / time.Second)),
+	}
+	data, err := b.Raw("getUpdates", params)
+	if err != nil {
+		return nil, err
+	}
+	var resp struct {
+		Ok          bool              `json:"ok"`
+		Result      []json.RawMessage `json:"result"`
+		Description string            `json:"description"`
+	}
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return nil, err
+	}
+	if !resp.Ok {
+		return nil, fmt.Errorf("telegram getUpdates: %s", resp.Description)
+	}
+	updates := make([]tb.Update, 0, len(resp.Result))
+	for _, raw := range resp.Result {
+		upd, err := decodeUpdate(raw)
+		if err != nil {
+			return nil, err
+		}
+		updates = append(updates, upd)
+	}
+	return updates, nil
+}
+
+// decodeUpdate decodes an update from the Bot API and remembers the
+// forward details of its message.
+func decodeUpdate(data []byte) (tb.Update, error) {
+	var upd tb.Update
+	if err := json.Unmarshal(data, &upd); err != nil {
+		return upd, err
+	}
+	var extra struct {
+		Message *forwardOrigin `json:"message"`
+	}
+	if err := json.Unmarshal(data, &extra); err == nil && upd.Message != nil && extra.Message != nil {
+		rememberForwardOrigin(upd.Message, extra.Message)
+	}
+	return upd, nil
+}
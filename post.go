@@ -0,0 +1,250 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/pyzh/fanfou-telegram-bot/fanfou"
+	tb "gopkg.in/tucnak/telebot.v2"
+)
+
+const postedMessageKind = "posted_messages"
+
+// postedStatus is a status created from a Telegram message, with what is
+// needed to post it again.
+type postedStatus struct {
+	Account           string
+	StatusID          string
+	InReplyToStatusID string
+	InReplyToUserID   string
+	// Mention is prepended to the text of replies.
+	Mention  string
+	Location string `json:",omitempty"`
+	// Thread holds the rest of a status split into a reply thread.
+	Thread []string `json:",omitempty"`
+}
+
+// postedMessage lists the statuses a Telegram message became.
+type postedMessage struct {
+	Statuses []postedStatus
+	// TelegramID linked the accounts of Statuses. Only channel posts set
+	// it; the other records are keyed by their owner.
+	TelegramID int `json:",omitempty"`
+}
+
+func handleText(m *tb.Message) {
+	ctx := context.Background()
+	if !m.Private() {
+		handleGroupText(ctx, m)
+		return
+	}
+	if m.ReplyTo != nil && (handleStatusReply(ctx, m) || handleDirectMessageReply(ctx, m)) {
+		return
+	}
+	text := m.Text
+	if isForward(m) {
+		var ok bool
+		if text, ok = forwardText(ctx, m, text); !ok {
+			askForward(ctx, m)
+			return
+		}
+	}
+	postText(ctx, m, text)
+}
+
+// postText posts text written in m to the posting accounts of its
+// sender.
+func postText(ctx context.Context, m *tb.Message, text string) {
+	infos, err := postingAccounts(ctx, m.Sender.ID)
+	if err != nil {
+		log.Println("get key error ", err)
+		return
+	}
+	location := takeLocation(ctx, m.Sender.ID)
+	targets := make([]postedStatus, len(infos))
+	for i, info := range infos {
+		targets[i] = postedStatus{Account: info.FanfouID, Location: location}
+	}
+	postStatuses(ctx, m, text, targets)
+}
+
+// handleStatusReply posts m as a Fanfou reply when it replies to a bot
+// message showing a status, and reports whether it did.
+func handleStatusReply(ctx context.Context, m *tb.Message) bool {
+	ref, err := lookupStatusMessage(ctx, m.Sender.ID, m.ReplyTo)
+	if err != nil {
+		return false
+	}
+	target := postedStatus{
+		Account:           ref.Account,
+		InReplyToStatusID: ref.StatusID,
+		InReplyToUserID:   ref.UserID,
+		Location:          takeLocation(ctx, m.Sender.ID),
+	}
+	// Replies to one's own statuses need no mention.
+	if ref.ScreenName != "" && ref.UserID != ref.Account {
+		target.Mention = "@" + ref.ScreenName + " "
+	}
+	postStatuses(ctx, m, m.Text, []postedStatus{target})
+	return true
+}
+
+// postStatuses posts text once per target, answers with receipts and
+// records the result so an edit of m can replace the statuses. Targets
+// of an edit are replaced: their old statuses are deleted once the new
+// one is posted.
+func postStatuses(ctx context.Context, m *tb.Message, text string, targets []postedStatus) {
+	if statusLength(strings.TrimSpace(text)) > splitLimit(targets) {
+		offerSplit(ctx, m, text, targets)
+		return
+	}
+	posted := &postedMessage{}
+	for _, target := range targets {
+		info, err := tokenStore.Get(ctx, m.Sender.ID, target.Account)
+		if err != nil {
+			log.Println("get key error ", err)
+			continue
+		}
+		status, err := clientFor(info).UpdateStatus(&fanfou.StatusParams{
+			Status:            target.Mention + text,
+			InReplyToStatusID: target.InReplyToStatusID,
+			InReplyToUserID:   target.InReplyToUserID,
+			Location:          target.Location,
+		})
+		if err != nil {
+			log.Println("call statuses update error ", err)
+			sendFanfouError(bot, m.Sender, err)
+			keepOld(posted, target)
+			continue
+		}
+		destroyOld(info, target)
+		target.StatusID, target.Thread = status.ID, nil
+		posted.Statuses = append(posted.Statuses, target)
+		sendReceipt(ctx, m.Sender.ID, info, status)
+	}
+	if len(posted.Statuses) > 0 {
+		rememberPosted(ctx, m.Sender.ID, messageKey(m), posted)
+	}
+}
+
+// messageKey identifies m among the messages of its sender. Message IDs
+// count per chat, so those from groups carry the chat ID.
+func messageKey(m *tb.Message) string {
+	if m.Private() {
+		return strconv.Itoa(m.ID)
+	}
+	return chatKey(m.Chat.ID) + "/" + strconv.Itoa(m.ID)
+}
+
+// rememberPosted records the statuses posted from message msgID.
+func rememberPosted(ctx context.Context, telegramID int, msgID string, posted *postedMessage) {
+	if err := recordStore.PutRecord(ctx, postedMessageKind, userKey(telegramID, msgID), posted); err != nil {
+		log.Println("remember posted message error ", err)
+	}
+}
+
+// destroyOld deletes the statuses target was posted as before an edit,
+// if any.
+func destroyOld(info *oauthInfo, target postedStatus) {
+	if target.StatusID == "" {
+		return
+	}
+	for _, id := range append([]string{target.StatusID}, target.Thread...) {
+		if _, err := clientFor(info).DestroyStatus(id); err != nil {
+			// It may have been deleted already.
+			log.Println("destroy edited status error ", err)
+		}
+	}
+}
+
+// keepOld keeps the statuses of an edit target that failed to post in
+// posted, so they are still replaced by the next edit.
+func keepOld(posted *postedMessage, target postedStatus) {
+	if target.StatusID != "" {
+		posted.Statuses = append(posted.Statuses, target)
+	}
+}
+
+// handleEdited replaces the statuses posted from a message with its new
+// text. Fanfou can't edit, so the old statuses are deleted once the new
+// text is posted; until then, or if the user cancels a split, they stay.
+func handleEdited(m *tb.Message) {
+	if m.Text == "" || !m.Private() {
+		return
+	}
+	ctx := context.Background()
+	settings, err := getSettings(ctx, m.Sender.ID)
+	if err != nil || settings.EditsDisabled {
+		return
+	}
+	posted := &postedMessage{}
+	key := userKey(m.Sender.ID, strconv.Itoa(m.ID))
+	if err := recordStore.GetRecord(ctx, postedMessageKind, key, posted); err != nil {
+		return
+	}
+	bot.Send(m.Sender, "Message edited, reposting it on Fanfou.")
+	postStatuses(ctx, m, m.Text, posted.Statuses)
+}
+
+func handleEdits(m *tb.Message) {
+	var disabled bool
+	switch strings.TrimSpace(m.Payload) {
+	case "on":
+	case "off":
+		disabled = true
+	default:
+		bot.Send(m.Sender, "Usage: /edits on|off. When on, editing a message you posted deletes the status and posts the new text.")
+		return
+	}
+	err := updateSettings(context.Background(), m.Sender.ID, func(s *userSettings) {
+		s.EditsDisabled = disabled
+	})
+	if err != nil {
+		log.Println("save settings error ", err)
+		return
+	}
+	if disabled {
+		bot.Send(m.Sender, "Edited messages are no longer reposted.")
+	} else {
+		bot.Send(m.Sender, "Edited messages will be reposted.")
+	}
+}
+
+func handlePhoto(m *tb.Message) {
+	log.Println("handle photo")
+	if m.AlbumID != "" {
+		collectAlbum(m)
+		return
+	}
+	data, filePath, err := downloadFile(m.Photo.FileID)
+	if err != nil {
+		log.Println("get file error ", err)
+		return
+	}
+	postPhoto(m, "Just posted a photo", filePath, data)
+}
+
+// downloadFile fetches a file sent to the bot, returning its contents
+// and its path on Telegram's servers.
+func downloadFile(fileID string) ([]byte, string, error) {
+	f, err := bot.FileByID(fileID)
+	if err != nil {
+		return nil, "", err
+	}
+	url := config.TelegramAPIURL + "/file/bot" + bot.Token + "/" + f.FilePath
+	resp, err := http.Get(url)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("download %s: %s", f.FilePath, resp.Status)
+	}
+	data, err := ioutil.ReadAll(resp.Body)
+	return data, f.FilePath, err
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"html"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pyzh/fanfou-telegram-bot/fanfou"
+	tb "gopkg.in/tucnak/telebot.v2"
+)
+
+var (
+	receiptDeleteBtn = tb.InlineButton{Unique: "receipt_delete", Text: "Delete"}
+	receiptUndoBtn   = tb.InlineButton{Unique: "receipt_undo", Text: "Undo"}
+)
+
+// signCallback returns statusID with a MAC binding it to telegramID, so a
+// button only works for the user it was sent to.
+func signCallback(telegramID int, statusID string) string {
+	return statusID + "." + callbackMAC(telegramID, statusID)
+}
+
+// verifyCallback checks data made by signCallback and returns the status
+// ID in it.
+func verifyCallback(telegramID int, data string) (string, bool) {
+	i := strings.LastIndex(data, ".")
+	if i < 0 {
+		return "", false
+	}
+	statusID, mac := data[:i], data[i+1:]
+	return statusID, hmac.Equal([]byte(mac), []byte(callbackMAC(telegramID, statusID)))
+}
+
+func callbackMAC(telegramID int, statusID string) string {
+	h := hmac.New(sha256.New, []byte(config.CallbackSecret))
+	h.Write([]byte(strconv.Itoa(telegramID) + ":" + statusID))
+	// 96 bits keep the data within Telegram's 64 byte limit.
+	return base64.RawURLEncoding.EncodeToString(h.Sum(nil)[:12])
+}
+
+func receiptHTML(info *oauthInfo, s *fanfou.Status, notes []string) string {
+	text := fmt.Sprintf("✅ Posted as <b>%s</b>\n%s\n%s",
+		html.EscapeString(info.ScreenName), html.EscapeString(html.UnescapeString(s.Text)), fanfou.StatusURL(s.ID))
+	if s.Photo != nil && s.Photo.LargeURL != "" {
+		// The link preview shows the uploaded photo.
+		text += fmt.Sprintf("\n<a href=\"%s\">📷 Photo</a>", s.Photo.LargeURL)
+	}
+	if len(notes) > 0 {
+		text += "\n<i>" + html.EscapeString(strings.Join(notes, ", ")) + "</i>"
+	}
+	return text
+}
+
+// sendReceipt tells telegramID that info posted s, with buttons to delete
+// or undo it. Replies to the receipt reply to the status. Notes say how
+// the post was changed on the way.
+func sendReceipt(ctx context.Context, telegramID int, info *oauthInfo, s *fanfou.Status, notes ...string) (*tb.Message, error) {
+	del, undo := receiptDeleteBtn, receiptUndoBtn
+	del.Data = signCallback(telegramID, s.ID)
+	undo.Data = del.Data
+	markup := &tb.ReplyMarkup{InlineKeyboard: [][]tb.InlineButton{{
+		del,
+		undo,
+		{Text: "Open", URL: fanfou.StatusURL(s.ID)},
+	}}}
+	m, err := bot.Send(&tb.User{ID: telegramID}, receiptHTML(info, s, notes), markup, tb.ModeHTML)
+	if err != nil {
+		return nil, err
+	}
+	if err := rememberStatusMessage(ctx, telegramID, m, newStatusRef(info.FanfouID, s)); err != nil {
+		log.Println("remember status message error ", err)
+	}
+	return m, nil
+}
+
+// receiptStatus resolves a receipt button press to the account and status
+// it acts on.
+func receiptStatus(ctx context.Context, c *tb.Callback) (*oauthInfo, string, bool) {
+	statusID, ok := verifyCallback(c.Sender.ID, c.Data)
+	if !ok {
+		bot.Respond(c, &tb.CallbackResponse{Text: "This button is not for you.", ShowAlert: true})
+		return nil, "", false
+	}
+	ref, err := lookupStatusMessage(ctx, c.Sender.ID, c.Message)
+	if err != nil || ref.StatusID != statusID {
+		bot.Respond(c, &tb.CallbackResponse{Text: "This status is no longer known."})
+		return nil, "", false
+	}
+	info, err := tokenStore.Get(ctx, c.Sender.ID, ref.Account)
+	if err != nil {
+		bot.Respond(c, &tb.CallbackResponse{Text: ref.Account + " is no longer linked."})
+		return nil, "", false
+	}
+	return info, statusID, true
+}
+
+func handleReceiptDelete(c *tb.Callback) {
+	ctx := context.Background()
+	info, statusID, ok := receiptStatus(ctx, c)
+	if !ok {
+		return
+	}
+	status, err := clientFor(info).DestroyStatus(statusID)
+	if err != nil {
+		respondFanfouError(c, err)
+		return
+	}
+	bot.Respond(c, &tb.CallbackResponse{Text: "Deleted"})
+	bot.Edit(c.Message, "🗑 Deleted: "+html.EscapeString(html.UnescapeString(status.Text)), tb.ModeHTML)
+}
+
+func handleReceiptUndo(c *tb.Callback) {
+	ctx := context.Background()
+	info, statusID, ok := receiptStatus(ctx, c)
+	if !ok {
+		return
+	}
+	if time.Since(c.Message.Time()) > config.undoGrace {
+		bot.Respond(c, &tb.CallbackResponse{Text: "Too late to undo, use Delete instead."})
+		return
+	}
+	status, err := clientFor(info).DestroyStatus(statusID)
+	if err != nil {
+		respondFanfouError(c, err)
+		return
+	}
+	bot.Respond(c, &tb.CallbackResponse{Text: "Undone"})
+	bot.Edit(c.Message, "↩️ Undone.")
+	// Hand the text back so it can be fixed and sent again.
+	bot.Send(c.Sender, html.UnescapeString(status.Text))
+}
+
+// respondFanfouError answers a button press with a Fanfou API error.
+func respondFanfouError(c *tb.Callback, err error) {
+	log.Println("fanfou error ", err)
+	text := "Failed, please try again."
+	if apiErr, ok := err.(*fanfou.Error); ok {
+		text = apiErr.Message
+	}
+	bot.Respond(c, &tb.CallbackResponse{Text: text})
+}
@@ -0,0 +1,41 @@
+package main
+
+import "testing"
+
+func TestVerifyCallback(t *testing.T) {
+	saved := config
+	config = &Config{CallbackSecret: "secret"}
+	defer func() { config = saved }()
+
+	signed := signCallback(42, "abc123")
+	tests := []struct {
+		name       string
+		telegramID int
+		data       string
+		wantID     string
+		wantOK     bool
+	}{
+		{"signed", 42, signed, "abc123", true},
+		{"dotted ID", 42, signCallback(42, "a.b"), "a.b", true},
+		{"other user", 43, signed, "abc123", false},
+		{"other ID", 42, "abc124" + signed[len("abc123"):], "abc124", false},
+		{"bad MAC", 42, "abc123.AAAAAAAAAAAAAAAA", "abc123", false},
+		{"no MAC", 42, "abc123", "", false},
+		{"empty", 42, "", "", false},
+	}
+	for _, tt := range tests {
+		id, ok := verifyCallback(tt.telegramID, tt.data)
+		if id != tt.wantID || ok != tt.wantOK {
+			t.Errorf("%s: verifyCallback(%d, %q) = %q, %v; want %q, %v", tt.name, tt.telegramID, tt.data, id, ok, tt.wantID, tt.wantOK)
+		}
+	}
+
+	config = &Config{CallbackSecret: "rotated"}
+	if _, ok := verifyCallback(42, signed); ok {
+		t.Error("data signed with another secret verified")
+	}
+	// Callback data is limited to 64 bytes.
+	if n := len(signCallback(2147483647, "0123456789012345678901")); n > 64 {
+		t.Errorf("signed data is %d bytes", n)
+	}
+}
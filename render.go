@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"log"
+
+	"github.com/pyzh/fanfou-telegram-bot/fanfou"
+	tb "gopkg.in/tucnak/telebot.v2"
+)
+
+// statusHTML renders a status as Telegram HTML: author, text and link.
+func statusHTML(s *fanfou.Status) string {
+	text := html.EscapeString(html.UnescapeString(s.Text))
+	link := fmt.Sprintf(`<a href="%s">%s</a>`, fanfou.StatusURL(s.ID), "Open")
+	if s.User == nil {
+		return text + "\n" + link
+	}
+	author := fmt.Sprintf(`<b>%s</b> <a href="%s">@%s</a>`,
+		html.EscapeString(s.User.ScreenName), fanfou.UserURL(s.User.ID), html.EscapeString(s.User.ID))
+	return author + "\n" + text + "\n" + link
+}
+
+// sendStatus delivers a status seen by account to telegramID, together
+// with its photo if it has one, and remembers both messages so replies to
+// them reach the status. options may add a *tb.ReplyMarkup to the text
+// message.
+func sendStatus(ctx context.Context, telegramID int, account string, s *fanfou.Status, options ...interface{}) (*tb.Message, error) {
+	to := &tb.User{ID: telegramID}
+	ref := newStatusRef(account, s)
+	opts := &tb.SendOptions{ParseMode: tb.ModeHTML}
+	if s.Photo != nil && s.Photo.LargeURL != "" {
+		// Photo captions are too short for the full text, so the photo
+		// goes first and the text follows as a reply to it.
+		photo := &tb.Photo{File: tb.FromURL(s.Photo.LargeURL)}
+		if m, err := bot.Send(to, photo); err == nil {
+			opts.ReplyTo = m
+			if err := rememberStatusMessage(ctx, telegramID, m, ref); err != nil {
+				log.Println("remember status message error ", err)
+			}
+		}
+	}
+	m, err := bot.Send(to, statusHTML(s), append([]interface{}{opts}, options...)...)
+	if err != nil {
+		return nil, err
+	}
+	if err := rememberStatusMessage(ctx, telegramID, m, ref); err != nil {
+		log.Println("remember status message error ", err)
+	}
+	return m, nil
+}
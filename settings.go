@@ -0,0 +1,50 @@
+package main
+
+import "context"
+
+const settingsKind = "settings"
+
+// userSettings holds the per-user preferences, one record per Telegram
+// user.
+type userSettings struct {
+	// ActiveAccount is the FanfouID posts go to by default.
+	ActiveAccount string
+	// FanoutAccounts, when not empty, receive every post instead of the
+	// active account alone.
+	FanoutAccounts []string
+	// EditsDisabled stops edited messages from being reposted.
+	EditsDisabled bool
+	// AlbumCollage posts albums as one collage instead of a series.
+	AlbumCollage bool
+	// AttachLocation saves shared locations for the next post instead of
+	// posting them.
+	AttachLocation bool
+	// ForwardMode says how forwards are posted: forwardAttribute (the
+	// default), forwardStrip or forwardAsk.
+	ForwardMode string
+}
+
+// getSettings returns the settings of telegramID, or the defaults when
+// none were saved.
+func getSettings(ctx context.Context, telegramID int) (*userSettings, error) {
+	s := &userSettings{}
+	err := recordStore.GetRecord(ctx, settingsKind, userKey(telegramID), s)
+	if err != nil && err != errNoRecord {
+		return nil, err
+	}
+	return s, nil
+}
+
+func putSettings(ctx context.Context, telegramID int, s *userSettings) error {
+	return recordStore.PutRecord(ctx, settingsKind, userKey(telegramID), s)
+}
+
+// updateSettings applies fn to the settings of telegramID and saves them.
+func updateSettings(ctx context.Context, telegramID int, fn func(s *userSettings)) error {
+	s, err := getSettings(ctx, telegramID)
+	if err != nil {
+		return err
+	}
+	fn(s)
+	return putSettings(ctx, telegramID, s)
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/pyzh/fanfou-telegram-bot/fanfou"
+	tb "gopkg.in/tucnak/telebot.v2"
+)
+
+// statusLimit is the longest status Fanfou accepts.
+const statusLimit = 140
+
+const splitDraftKind = "split_drafts"
+
+var (
+	splitPostBtn   = tb.InlineButton{Unique: "split_post", Text: "Post thread"}
+	splitNumberBtn = tb.InlineButton{Unique: "split_number"}
+	splitCancelBtn = tb.InlineButton{Unique: "split_cancel", Text: "Cancel"}
+)
+
+var urlPattern = regexp.MustCompile(`https?://[^\s]+`)
+
+// sentenceEnds are the runes a sentence may end with.
+const sentenceEnds = ".!?。！？…；;"
+
+// splitDraft is a long message waiting for the user to confirm how it
+// is split.
+type splitDraft struct {
+	Text     string
+	Targets  []postedStatus
+	Numbered bool
+}
+
+// statusLength counts text the way Fanfou does: one per code point, so a
+// CJK character counts as much as a Latin letter. Links are not
+// shortened and count in full.
+func statusLength(text string) int {
+	return utf8.RuneCountInString(text)
+}
+
+// splitStatus breaks text into statuses of at most limit characters,
+// ending each with " (i/n)" when numbered.
+func splitStatus(text string, limit int, numbered bool) []string {
+	text = strings.TrimSpace(text)
+	if statusLength(text) <= limit {
+		return []string{text}
+	}
+	if !numbered {
+		return splitText(text, limit)
+	}
+	// Leave room for the widest suffix; widen it if the count needs
+	// more digits.
+	for digits := 1; ; digits++ {
+		parts := splitText(text, limit-2*digits-4)
+		if len(strconv.Itoa(len(parts))) <= digits {
+			for i := range parts {
+				parts[i] += fmt.Sprintf(" (%d/%d)", i+1, len(parts))
+			}
+			return parts
+		}
+	}
+}
+
+func splitText(text string, limit int) []string {
+	var parts []string
+	runes := []rune(text)
+	for len(runes) > limit {
+		cut := breakPoint(runes, limit)
+		if part := strings.TrimSpace(string(runes[:cut])); part != "" {
+			parts = append(parts, part)
+		}
+		runes = []rune(strings.TrimLeftFunc(string(runes[cut:]), unicode.IsSpace))
+	}
+	if len(runes) > 0 {
+		parts = append(parts, string(runes))
+	}
+	return parts
+}
+
+// breakPoint picks where to cut runes within limit: after a sentence if
+// one ends in the second half, else at a space or between CJK
+// characters, and never inside a link unless the link alone is too long.
+func breakPoint(runes []rune, limit int) int {
+	inURL := make([]bool, len(runes)+1)
+	s := string(runes)
+	for _, loc := range urlPattern.FindAllStringIndex(s, -1) {
+		start := utf8.RuneCountInString(s[:loc[0]])
+		end := start + utf8.RuneCountInString(s[loc[0]:loc[1]])
+		for i := start + 1; i < end; i++ {
+			inURL[i] = true
+		}
+	}
+	var sentence, word, any int
+	for i := 1; i <= limit && i < len(runes); i++ {
+		if inURL[i] {
+			continue
+		}
+		prev, next := runes[i-1], runes[i]
+		switch {
+		case strings.ContainsRune(sentenceEnds, prev) && (prev > unicode.MaxASCII || unicode.IsSpace(next)):
+			sentence = i
+		case unicode.IsSpace(prev) || unicode.IsSpace(next) || isCJK(prev) || isCJK(next):
+			word = i
+		}
+		any = i
+	}
+	switch {
+	case sentence >= limit/2:
+		return sentence
+	case word > 0:
+		return word
+	case any > 0:
+		return any
+	}
+	return limit
+}
+
+func isCJK(r rune) bool {
+	return unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul) ||
+		unicode.Is(unicode.Ideographic, r)
+}
+
+// splitLimit is the room left for text when replying to targets.
+func splitLimit(targets []postedStatus) int {
+	limit := statusLimit
+	for _, t := range targets {
+		if n := statusLimit - statusLength(t.Mention); n < limit {
+			limit = n
+		}
+	}
+	return limit
+}
+
+func splitPreviewHTML(d *splitDraft) string {
+	parts := splitStatus(d.Text, splitLimit(d.Targets), d.Numbered)
+	var b strings.Builder
+	fmt.Fprintf(&b, "✂️ This message has %d characters, more than Fanfou's %d. It will be posted as a thread of %d statuses:\n",
+		statusLength(d.Text), statusLimit, len(parts))
+	// Keep the preview within Telegram's message limit.
+	abbreviate := statusLength(d.Text)+len(parts)*16 > 3800
+	for i, part := range parts {
+		if r := []rune(part); abbreviate && len(r) > 40 {
+			part = string(r[:40]) + "…"
+		}
+		fmt.Fprintf(&b, "\n<b>%d.</b> %s\n", i+1, html.EscapeString(part))
+	}
+	return b.String()
+}
+
+func splitMarkup(telegramID int, msgID string, d *splitDraft) *tb.ReplyMarkup {
+	post, number, cancel := splitPostBtn, splitNumberBtn, splitCancelBtn
+	post.Data = signCallback(telegramID, msgID)
+	number.Data, cancel.Data = post.Data, post.Data
+	number.Text = "Add (1/n)"
+	if d.Numbered {
+		number.Text = "Remove (1/n)"
+	}
+	return &tb.ReplyMarkup{InlineKeyboard: [][]tb.InlineButton{{post, number, cancel}}}
+}
+
+// offerSplit saves text as a draft and shows how it would be split,
+// waiting for the user to post it.
+func offerSplit(ctx context.Context, m *tb.Message, text string, targets []postedStatus) {
+	msgID := messageKey(m)
+	d := &splitDraft{Text: text, Targets: targets, Numbered: true}
+	if err := recordStore.PutRecord(ctx, splitDraftKind, userKey(m.Sender.ID, msgID), d); err != nil {
+		log.Println("save split draft error ", err)
+		return
+	}
+	bot.Send(m.Sender, splitPreviewHTML(d), splitMarkup(m.Sender.ID, msgID, d), tb.ModeHTML)
+}
+
+// splitDraftFor resolves a preview button press to its draft.
+func splitDraftFor(ctx context.Context, c *tb.Callback) (string, *splitDraft, bool) {
+	msgID, ok := verifyCallback(c.Sender.ID, c.Data)
+	if !ok {
+		bot.Respond(c, &tb.CallbackResponse{Text: "This button is not for you.", ShowAlert: true})
+		return "", nil, false
+	}
+	d := &splitDraft{}
+	if err := recordStore.GetRecord(ctx, splitDraftKind, userKey(c.Sender.ID, msgID), d); err != nil {
+		bot.Respond(c, &tb.CallbackResponse{Text: "This draft is gone."})
+		bot.Edit(c.Message, "This draft is gone.")
+		return "", nil, false
+	}
+	return msgID, d, true
+}
+
+func handleSplitNumber(c *tb.Callback) {
+	ctx := context.Background()
+	msgID, d, ok := splitDraftFor(ctx, c)
+	if !ok {
+		return
+	}
+	d.Numbered = !d.Numbered
+	if err := recordStore.PutRecord(ctx, splitDraftKind, userKey(c.Sender.ID, msgID), d); err != nil {
+		log.Println("save split draft error ", err)
+		bot.Respond(c, &tb.CallbackResponse{Text: "Failed, please try again."})
+		return
+	}
+	bot.Respond(c)
+	bot.Edit(c.Message, splitPreviewHTML(d), splitMarkup(c.Sender.ID, msgID, d), tb.ModeHTML)
+}
+
+func handleSplitCancel(c *tb.Callback) {
+	ctx := context.Background()
+	msgID, _, ok := splitDraftFor(ctx, c)
+	if !ok {
+		return
+	}
+	if err := recordStore.DeleteRecord(ctx, splitDraftKind, userKey(c.Sender.ID, msgID)); err != nil {
+		log.Println("delete split draft error ", err)
+	}
+	handleCancel(c)
+}
+
+func handleSplitPost(c *tb.Callback) {
+	ctx := context.Background()
+	msgID, ok := verifyCallback(c.Sender.ID, c.Data)
+	if !ok {
+		bot.Respond(c, &tb.CallbackResponse{Text: "This button is not for you.", ShowAlert: true})
+		return
+	}
+	// Taking the draft keeps a double tap from posting twice.
+	d := &splitDraft{}
+	if err := recordStore.TakeRecord(ctx, splitDraftKind, userKey(c.Sender.ID, msgID), d); err != nil {
+		bot.Respond(c, &tb.CallbackResponse{Text: "This draft is gone."})
+		return
+	}
+	bot.Respond(c, &tb.CallbackResponse{Text: "Posting…"})
+	parts := splitStatus(d.Text, splitLimit(d.Targets), d.Numbered)
+
+	posted := &postedMessage{}
+	var links []string
+	for _, target := range d.Targets {
+		info, err := tokenStore.Get(ctx, c.Sender.ID, target.Account)
+		if err != nil {
+			log.Println("get key error ", err)
+			keepOld(posted, target)
+			continue
+		}
+		statuses, err := postThread(info, target, parts)
+		if err != nil {
+			log.Println("post thread error ", err)
+			sendFanfouError(bot, c.Sender, err)
+		}
+		if len(statuses) == 0 {
+			keepOld(posted, target)
+			continue
+		}
+		// Targets of edited messages still carry the statuses they
+		// replace.
+		destroyOld(info, target)
+		target.StatusID, target.Thread = statuses[0].ID, nil
+		for i, s := range statuses {
+			if i > 0 {
+				target.Thread = append(target.Thread, s.ID)
+			}
+			links = append(links, fmt.Sprintf("<a href=\"%s\">%d</a>", fanfou.StatusURL(s.ID), i+1))
+		}
+		posted.Statuses = append(posted.Statuses, target)
+		sendReceipt(ctx, c.Sender.ID, info, statuses[0])
+	}
+	if len(links) == 0 {
+		bot.Edit(c.Message, "Nothing was posted.")
+		return
+	}
+	rememberPosted(ctx, c.Sender.ID, msgID, posted)
+	bot.Edit(c.Message, fmt.Sprintf("🧵 Posted a thread of %d statuses: %s", len(parts), strings.Join(links, " ")), tb.ModeHTML)
+}
+
+// postThread posts parts as info, each replying to the one before. The
+// first part replies as target does. It stops at the first error and
+// returns what was posted.
+func postThread(info *oauthInfo, target postedStatus, parts []string) ([]*fanfou.Status, error) {
+	var statuses []*fanfou.Status
+	params := &fanfou.StatusParams{
+		InReplyToStatusID: target.InReplyToStatusID,
+		InReplyToUserID:   target.InReplyToUserID,
+		Location:          target.Location,
+	}
+	for i, part := range parts {
+		params.Status = part
+		if i == 0 {
+			params.Status = target.Mention + part
+		}
+		status, err := clientFor(info).UpdateStatus(params)
+		if err != nil {
+			return statuses, err
+		}
+		statuses = append(statuses, status)
+		params.InReplyToStatusID = status.ID
+		params.InReplyToUserID = info.FanfouID
+	}
+	return statuses, nil
+}
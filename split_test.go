@@ -0,0 +1,65 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSplitStatus(t *testing.T) {
+	tests := []struct {
+		text     string
+		limit    int
+		numbered bool
+		want     []string
+	}{
+		{"short enough", 20, false, []string{"short enough"}},
+		{"  trimmed  ", 20, true, []string{"trimmed"}},
+		{"one two three four", 10, false, []string{"one two", "three four"}},
+		{"Hi there. This is long", 14, false, []string{"Hi there.", "This is long"}},
+		{"一二三四五六七八", 5, false, []string{"一二三四五", "六七八"}},
+		{"see https://example.com/x now", 24, false, []string{"see", "https://example.com/x", "now"}},
+		{"aaaa bbbb cccc dddd", 12, true, []string{"aaaa (1/4)", "bbbb (2/4)", "cccc (3/4)", "dddd (4/4)"}},
+		{"aaaa bbbb cccc dddd", 15, true, []string{"aaaa bbbb (1/2)", "cccc dddd (2/2)"}},
+	}
+	for _, tt := range tests {
+		got := splitStatus(tt.text, tt.limit, tt.numbered)
+		if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+			t.Errorf("splitStatus(%q, %d, %v) = %q, want %q", tt.text, tt.limit, tt.numbered, got, tt.want)
+		}
+	}
+}
+
+func TestSplitStatusLimit(t *testing.T) {
+	text := strings.Repeat("Lorem ipsum dolor sit amet. 敏捷的狐狸跳过了懒狗。", 20)
+	for _, numbered := range []bool{false, true} {
+		for _, part := range splitStatus(text, statusLimit, numbered) {
+			if n := statusLength(part); n > statusLimit {
+				t.Errorf("numbered %v: part of %d characters: %q", numbered, n, part)
+			}
+		}
+	}
+}
+
+func TestBreakPoint(t *testing.T) {
+	tests := []struct {
+		text  string
+		limit int
+		want  int
+	}{
+		// The sentence ends in the second half.
+		{"Hello world. More text", 15, 12},
+		// Too early for a sentence, so the last word break wins.
+		{"Hi. There are words", 15, 14},
+		{"中文。再来一些", 5, 3},
+		{"中文再来一些", 4, 4},
+		// No break inside the link.
+		{"ab http://x.io/abc", 10, 3},
+		// Nothing to break at: cut at the limit.
+		{"abcdefghij", 5, 5},
+	}
+	for _, tt := range tests {
+		if got := breakPoint([]rune(tt.text), tt.limit); got != tt.want {
+			t.Errorf("breakPoint(%q, %d) = %d, want %d", tt.text, tt.limit, got, tt.want)
+		}
+	}
+}
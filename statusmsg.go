@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/pyzh/fanfou-telegram-bot/fanfou"
+	tb "gopkg.in/tucnak/telebot.v2"
+)
+
+const statusMessageKind = "status_messages"
+
+// statusRef ties a bot message to the Fanfou status it shows.
+type statusRef struct {
+	// Account is the FanfouID the status was seen or posted as.
+	Account    string
+	StatusID   string
+	UserID     string
+	ScreenName string
+}
+
+func newStatusRef(account string, s *fanfou.Status) *statusRef {
+	ref := &statusRef{Account: account, StatusID: s.ID}
+	if s.User != nil {
+		ref.UserID, ref.ScreenName = s.User.ID, s.User.ScreenName
+	}
+	return ref
+}
+
+// rememberStatusMessage records that m, sent to telegramID, shows ref.
+func rememberStatusMessage(ctx context.Context, telegramID int, m *tb.Message, ref *statusRef) error {
+	return recordStore.PutRecord(ctx, statusMessageKind, userKey(telegramID, strconv.Itoa(m.ID)), ref)
+}
+
+func lookupStatusMessage(ctx context.Context, telegramID int, m *tb.Message) (*statusRef, error) {
+	ref := &statusRef{}
+	if err := recordStore.GetRecord(ctx, statusMessageKind, userKey(telegramID, strconv.Itoa(m.ID)), ref); err != nil {
+		return nil, err
+	}
+	return ref, nil
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// TokenStore persists the Fanfou access tokens linked to each Telegram
+// user, one per Fanfou account. Tokens saved before accounts were tracked
+// have an empty FanfouID and are addressed with "".
+type TokenStore interface {
+	Get(ctx context.Context, telegramID int, fanfouID string) (*oauthInfo, error)
+	List(ctx context.Context, telegramID int) ([]*oauthInfo, error)
+	// Put adds or replaces the token of account info.FanfouID.
+	Put(ctx context.Context, telegramID int, info *oauthInfo) error
+	Delete(ctx context.Context, telegramID int, fanfouID string) error
+}
+
+// RecordStore keeps the rest of the bot state as small JSON-encoded
+// records, addressed by kind and key.
+type RecordStore interface {
+	GetRecord(ctx context.Context, kind, key string, v interface{}) error
+	PutRecord(ctx context.Context, kind, key string, v interface{}) error
+	DeleteRecord(ctx context.Context, kind, key string) error
+	// TakeRecord reads and deletes a record atomically, so only one of
+	// several concurrent callers gets it.
+	TakeRecord(ctx context.Context, kind, key string, v interface{}) error
+	// UpdateRecord reads a record into v, lets fn change it and saves it
+	// atomically, so changes made meanwhile by others are not lost. It
+	// returns errNoRecord, without calling fn, if the record is gone.
+	UpdateRecord(ctx context.Context, kind, key string, v interface{}, fn func() error) error
+	// RecordKeys lists the keys of kind that start with prefix.
+	RecordKeys(ctx context.Context, kind, prefix string) ([]string, error)
+}
+
+// Store is implemented by every storage backend.
+type Store interface {
+	TokenStore
+	RecordStore
+}
+
+// errNoToken is returned by TokenStore.Get for accounts that are not
+// linked.
+var errNoToken = errors.New("no Fanfou account linked")
+
+// errNoRecord is returned by RecordStore for missing records.
+var errNoRecord = errors.New("record not found")
+
+// newStore returns the Store named by backend: "datastore", "file" (a
+// JSON file at path) or "memory".
+func newStore(ctx context.Context, backend, projectID, path string) (Store, error) {
+	switch backend {
+	case "", "datastore":
+		return newDatastoreStore(ctx, projectID)
+	case "file":
+		return newFileStore(path)
+	case "memory":
+		return newMemoryStore(), nil
+	}
+	return nil, fmt.Errorf("unknown token store %q", backend)
+}
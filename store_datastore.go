@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+
+	"cloud.google.com/go/datastore"
+)
+
+const tokenKind = "fanfou_tokens"
+
+// datastoreStore keeps tokens and records in Google Cloud Datastore.
+type datastoreStore struct {
+	client *datastore.Client
+}
+
+func newDatastoreStore(ctx context.Context, projectID string) (*datastoreStore, error) {
+	client, err := datastore.NewClient(ctx, projectID)
+	if err != nil {
+		return nil, err
+	}
+	return &datastoreStore{client: client}, nil
+}
+
+func (s *datastoreStore) Get(ctx context.Context, telegramID int, fanfouID string) (*oauthInfo, error) {
+	info := &oauthInfo{}
+	err := s.client.Get(ctx, tokenKey(telegramID, fanfouID), info)
+	if err == datastore.ErrNoSuchEntity {
+		return nil, errNoToken
+	}
+	if err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+func (s *datastoreStore) List(ctx context.Context, telegramID int) ([]*oauthInfo, error) {
+	// The ancestor query includes the legacy token saved at the root key.
+	var infos []*oauthInfo
+	q := datastore.NewQuery(tokenKind).Ancestor(tokenKey(telegramID, ""))
+	if _, err := s.client.GetAll(ctx, q, &infos); err != nil {
+		return nil, err
+	}
+	return infos, nil
+}
+
+func (s *datastoreStore) Put(ctx context.Context, telegramID int, info *oauthInfo) error {
+	_, err := s.client.Put(ctx, tokenKey(telegramID, info.FanfouID), info)
+	return err
+}
+
+func (s *datastoreStore) Delete(ctx context.Context, telegramID int, fanfouID string) error {
+	return s.client.Delete(ctx, tokenKey(telegramID, fanfouID))
+}
+
+// tokenKey is fanfou_tokens/<telegramID> for the legacy single token and
+// a child of it for each linked account.
+func tokenKey(telegramID int, fanfouID string) *datastore.Key {
+	root := datastore.IDKey(tokenKind, int64(telegramID), nil)
+	if fanfouID == "" {
+		return root
+	}
+	return datastore.NameKey(tokenKind, fanfouID, root)
+}
+
+// record is the entity a RecordStore record is saved as.
+type record struct {
+	Data []byte `datastore:",noindex"`
+}
+
+func (s *datastoreStore) GetRecord(ctx context.Context, kind, key string, v interface{}) error {
+	r := &record{}
+	err := s.client.Get(ctx, datastore.NameKey(kind, key, nil), r)
+	if err == datastore.ErrNoSuchEntity {
+		return errNoRecord
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(r.Data, v)
+}
+
+func (s *datastoreStore) PutRecord(ctx context.Context, kind, key string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = s.client.Put(ctx, datastore.NameKey(kind, key, nil), &record{Data: data})
+	return err
+}
+
+func (s *datastoreStore) DeleteRecord(ctx context.Context, kind, key string) error {
+	return s.client.Delete(ctx, datastore.NameKey(kind, key, nil))
+}
+
+func (s *datastoreStore) TakeRecord(ctx context.Context, kind, key string, v interface{}) error {
+	k := datastore.NameKey(kind, key, nil)
+	r := &record{}
+	_, err := s.client.RunInTransaction(ctx, func(tx *datastore.Transaction) error {
+		if err := tx.Get(k, r); err != nil {
+			return err
+		}
+		return tx.Delete(k)
+	})
+	if err == datastore.ErrNoSuchEntity {
+		return errNoRecord
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(r.Data, v)
+}
+
+func (s *datastoreStore) UpdateRecord(ctx context.Context, kind, key string, v interface{}, fn func() error) error {
+	k := datastore.NameKey(kind, key, nil)
+	_, err := s.client.RunInTransaction(ctx, func(tx *datastore.Transaction) error {
+		r := &record{}
+		if err := tx.Get(k, r); err != nil {
+			return err
+		}
+		if err := json.Unmarshal(r.Data, v); err != nil {
+			return err
+		}
+		if err := fn(); err != nil {
+			return err
+		}
+		data, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Put(k, &record{Data: data})
+		return err
+	})
+	if err == datastore.ErrNoSuchEntity {
+		return errNoRecord
+	}
+	return err
+}
+
+func (s *datastoreStore) RecordKeys(ctx context.Context, kind, prefix string) ([]string, error) {
+	q := datastore.NewQuery(kind).KeysOnly()
+	if prefix != "" {
+		q = q.Filter("__key__ >=", datastore.NameKey(kind, prefix, nil)).
+			Filter("__key__ <", datastore.NameKey(kind, prefix+"\uffff", nil))
+	}
+	keys, err := s.client.GetAll(ctx, q, nil)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, len(keys))
+	for i, k := range keys {
+		names[i] = k.Name
+	}
+	return names, nil
+}
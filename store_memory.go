@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// memoryStore keeps tokens and records in memory. When path is set every change is
+// written to that JSON file, which makes it usable for self-hosting.
+type memoryStore struct {
+	mu   sync.Mutex
+	path string
+	data memoryData
+}
+
+type memoryData struct {
+	Accounts map[int]map[string]oauthInfo          `json:"accounts"`
+	Records  map[string]map[string]json.RawMessage `json:"records"`
+
+	// Tokens is the single-account layout of older files.
+	Tokens map[int]oauthInfo `json:"tokens,omitempty"`
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{data: memoryData{
+		Accounts: map[int]map[string]oauthInfo{},
+		Records:  map[string]map[string]json.RawMessage{},
+	}}
+}
+
+// newFileStore loads the store at path, creating it on first write.
+func newFileStore(path string) (*memoryStore, error) {
+	s := newMemoryStore()
+	s.path = path
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &s.data); err != nil {
+		return nil, err
+	}
+	if s.data.Accounts == nil {
+		s.data.Accounts = map[int]map[string]oauthInfo{}
+	}
+	for telegramID, info := range s.data.Tokens {
+		if s.data.Accounts[telegramID] == nil {
+			s.data.Accounts[telegramID] = map[string]oauthInfo{}
+		}
+		s.data.Accounts[telegramID][info.FanfouID] = info
+	}
+	s.data.Tokens = nil
+	if s.data.Records == nil {
+		s.data.Records = map[string]map[string]json.RawMessage{}
+	}
+	return s, nil
+}
+
+func (s *memoryStore) Get(ctx context.Context, telegramID int, fanfouID string) (*oauthInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	info, ok := s.data.Accounts[telegramID][fanfouID]
+	if !ok {
+		return nil, errNoToken
+	}
+	return &info, nil
+}
+
+func (s *memoryStore) List(ctx context.Context, telegramID int) ([]*oauthInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var infos []*oauthInfo
+	for _, info := range s.data.Accounts[telegramID] {
+		info := info
+		infos = append(infos, &info)
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].FanfouID < infos[j].FanfouID })
+	return infos, nil
+}
+
+func (s *memoryStore) Put(ctx context.Context, telegramID int, info *oauthInfo) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.data.Accounts[telegramID] == nil {
+		s.data.Accounts[telegramID] = map[string]oauthInfo{}
+	}
+	s.data.Accounts[telegramID][info.FanfouID] = *info
+	return s.save()
+}
+
+func (s *memoryStore) Delete(ctx context.Context, telegramID int, fanfouID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.data.Accounts[telegramID], fanfouID)
+	if len(s.data.Accounts[telegramID]) == 0 {
+		delete(s.data.Accounts, telegramID)
+	}
+	return s.save()
+}
+
+func (s *memoryStore) GetRecord(ctx context.Context, kind, key string, v interface{}) error {
+	s.mu.Lock()
+	data, ok := s.data.Records[kind][key]
+	s.mu.Unlock()
+	if !ok {
+		return errNoRecord
+	}
+	return json.Unmarshal(data, v)
+}
+
+func (s *memoryStore) PutRecord(ctx context.Context, kind, key string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.data.Records[kind] == nil {
+		s.data.Records[kind] = map[string]json.RawMessage{}
+	}
+	s.data.Records[kind][key] = data
+	return s.save()
+}
+
+func (s *memoryStore) DeleteRecord(ctx context.Context, kind, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.data.Records[kind], key)
+	return s.save()
+}
+
+func (s *memoryStore) TakeRecord(ctx context.Context, kind, key string, v interface{}) error {
+	s.mu.Lock()
+	data, ok := s.data.Records[kind][key]
+	if !ok {
+		s.mu.Unlock()
+		return errNoRecord
+	}
+	delete(s.data.Records[kind], key)
+	err := s.save()
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+func (s *memoryStore) UpdateRecord(ctx context.Context, kind, key string, v interface{}, fn func() error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, ok := s.data.Records[kind][key]
+	if !ok {
+		return errNoRecord
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return err
+	}
+	if err := fn(); err != nil {
+		return err
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	s.data.Records[kind][key] = data
+	return s.save()
+}
+
+func (s *memoryStore) RecordKeys(ctx context.Context, kind, prefix string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var keys []string
+	for k := range s.data.Records[kind] {
+		if strings.HasPrefix(k, prefix) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// save writes the store to s.path. Callers must hold s.mu.
+func (s *memoryStore) save() error {
+	if s.path == "" {
+		return nil
+	}
+	b, err := json.Marshal(&s.data)
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(s.path), filepath.Base(s.path))
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
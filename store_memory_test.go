@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type testRecord struct {
+	N int
+}
+
+func TestMemoryStoreRecords(t *testing.T) {
+	ctx := context.Background()
+	s := newMemoryStore()
+	r := &testRecord{}
+	if err := s.GetRecord(ctx, "k", "a", r); err != errNoRecord {
+		t.Errorf("GetRecord of a missing record = %v", err)
+	}
+	if err := s.PutRecord(ctx, "k", "a", &testRecord{N: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.GetRecord(ctx, "k", "a", r); err != nil || r.N != 1 {
+		t.Errorf("GetRecord = %+v, %v", r, err)
+	}
+	if err := s.DeleteRecord(ctx, "k", "a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.GetRecord(ctx, "k", "a", r); err != errNoRecord {
+		t.Errorf("GetRecord after DeleteRecord = %v", err)
+	}
+}
+
+func TestMemoryStoreTakeRecord(t *testing.T) {
+	ctx := context.Background()
+	s := newMemoryStore()
+	s.PutRecord(ctx, "k", "a", &testRecord{N: 1})
+	r := &testRecord{}
+	if err := s.TakeRecord(ctx, "k", "a", r); err != nil || r.N != 1 {
+		t.Errorf("TakeRecord = %+v, %v", r, err)
+	}
+	if err := s.TakeRecord(ctx, "k", "a", r); err != errNoRecord {
+		t.Errorf("second TakeRecord = %v, want errNoRecord", err)
+	}
+}
+
+func TestMemoryStoreUpdateRecord(t *testing.T) {
+	ctx := context.Background()
+	s := newMemoryStore()
+	r := &testRecord{}
+	called := false
+	err := s.UpdateRecord(ctx, "k", "a", r, func() error {
+		called = true
+		return nil
+	})
+	if err != errNoRecord || called {
+		t.Errorf("UpdateRecord of a missing record = %v, fn called %v", err, called)
+	}
+
+	s.PutRecord(ctx, "k", "a", &testRecord{N: 1})
+	if err := s.UpdateRecord(ctx, "k", "a", r, func() error {
+		r.N++
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	failed := errors.New("failed")
+	err = s.UpdateRecord(ctx, "k", "a", r, func() error {
+		r.N = 100
+		return failed
+	})
+	if err != failed {
+		t.Errorf("UpdateRecord with a failing fn = %v", err)
+	}
+	if err := s.UpdateRecord(ctx, "k", "a", r, func() error { return errNoRecord }); err != errNoRecord {
+		t.Errorf("UpdateRecord with fn returning errNoRecord = %v", err)
+	}
+	if err := s.GetRecord(ctx, "k", "a", r); err != nil || r.N != 2 {
+		t.Errorf("record after updates = %+v, %v; want N 2", r, err)
+	}
+}
+
+func TestMemoryStoreRecordKeys(t *testing.T) {
+	ctx := context.Background()
+	s := newMemoryStore()
+	for _, key := range []string{"2:d", "1:b", "10:c", "1:a", "1"} {
+		s.PutRecord(ctx, "k", key, &testRecord{})
+	}
+	s.PutRecord(ctx, "other", "1:z", &testRecord{})
+	tests := []struct {
+		prefix string
+		want   []string
+	}{
+		{"", []string{"1", "10:c", "1:a", "1:b", "2:d"}},
+		{"1:", []string{"1:a", "1:b"}},
+		{"1", []string{"1", "10:c", "1:a", "1:b"}},
+		{"3", nil},
+	}
+	for _, tt := range tests {
+		got, err := s.RecordKeys(ctx, "k", tt.prefix)
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("RecordKeys(%q) = %q, %v; want %q", tt.prefix, got, err, tt.want)
+		}
+	}
+	if got, _ := s.RecordKeys(ctx, "none", ""); len(got) != 0 {
+		t.Errorf("RecordKeys of an empty kind = %q", got)
+	}
+}
+
+func TestMemoryStoreTokens(t *testing.T) {
+	ctx := context.Background()
+	s := newMemoryStore()
+	if _, err := s.Get(ctx, 1, "a"); err != errNoToken {
+		t.Errorf("Get of a missing token = %v", err)
+	}
+	s.Put(ctx, 1, &oauthInfo{Token: "tb", FanfouID: "b"})
+	s.Put(ctx, 1, &oauthInfo{Token: "ta", FanfouID: "a"})
+	infos, err := s.List(ctx, 1)
+	if err != nil || len(infos) != 2 || infos[0].FanfouID != "a" || infos[1].FanfouID != "b" {
+		t.Errorf("List = %+v, %v", infos, err)
+	}
+	s.Delete(ctx, 1, "a")
+	s.Delete(ctx, 1, "b")
+	if infos, _ := s.List(ctx, 1); len(infos) != 0 {
+		t.Errorf("List after Delete = %+v", infos)
+	}
+}
+
+func TestFileStoreReload(t *testing.T) {
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "store.json")
+
+	s, err := newFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Put(ctx, 1, &oauthInfo{Token: "t", Secret: "s", FanfouID: "a"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.PutRecord(ctx, "k", "1:a", &testRecord{N: 7}); err != nil {
+		t.Fatal(err)
+	}
+
+	reloaded, err := newFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info, err := reloaded.Get(ctx, 1, "a"); err != nil || info.Token != "t" || info.Secret != "s" {
+		t.Errorf("reloaded Get = %+v, %v", info, err)
+	}
+	r := &testRecord{}
+	if err := reloaded.GetRecord(ctx, "k", "1:a", r); err != nil || r.N != 7 {
+		t.Errorf("reloaded GetRecord = %+v, %v", r, err)
+	}
+}
+
+func TestFileStoreLegacyTokens(t *testing.T) {
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "store.json")
+	legacy := `{"tokens":{"5":{"Token":"t","Secret":"s"},"6":{"Token":"u","Secret":"v","FanfouID":"bob"}}}`
+	if err := ioutil.WriteFile(path, []byte(legacy), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := newFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info, err := s.Get(ctx, 5, ""); err != nil || info.Token != "t" {
+		t.Errorf("legacy token = %+v, %v", info, err)
+	}
+	if info, err := s.Get(ctx, 6, "bob"); err != nil || info.Token != "u" {
+		t.Errorf("legacy token with an account = %+v, %v", info, err)
+	}
+	// Records work on a file without them.
+	if err := s.PutRecord(ctx, "k", "a", &testRecord{}); err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(b), `"tokens"`) {
+		t.Errorf("legacy layout written back: %s", b)
+	}
+}
+
+func TestFileStoreMissing(t *testing.T) {
+	s, err := newFileStore(filepath.Join(os.TempDir(), "no-such-dir-fanfou", "store.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Get(context.Background(), 1, ""); err != errNoToken {
+		t.Errorf("Get from a new store = %v", err)
+	}
+}
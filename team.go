@@ -0,0 +1,416 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pyzh/fanfou-telegram-bot/fanfou"
+	tb "gopkg.in/tucnak/telebot.v2"
+)
+
+const (
+	teamGroupKind = "team_groups"
+	teamDraftKind = "team_drafts"
+	teamAuditKind = "team_audit"
+)
+
+// teamAuditShown is how many entries /team log shows.
+const teamAuditShown = 10
+
+var (
+	teamApproveBtn = tb.InlineButton{Unique: "team_approve", Text: "Approve"}
+	teamRejectBtn  = tb.InlineButton{Unique: "team_reject", Text: "Reject"}
+)
+
+// teamGroup lets members of a Telegram group propose posts for a shared
+// Fanfou account, keyed by the group's chat ID. Posts go out with the
+// token TelegramID linked for Account.
+type teamGroup struct {
+	ChatID     int64
+	TelegramID int
+	Account    string
+	// Approvers, when not empty, are the only users who may approve;
+	// otherwise the group's administrators may.
+	Approvers []int
+}
+
+// teamDraft is a proposed post, keyed by the group and the message
+// proposing it.
+type teamDraft struct {
+	Text       string
+	AuthorID   int
+	AuthorName string
+}
+
+// teamAuditEntry records a decision on a draft.
+type teamAuditEntry struct {
+	Time       time.Time
+	Action     string
+	AuthorID   int `json:",omitempty"`
+	AuthorName string
+	DeciderID  int
+	Decider    string
+	Text       string
+	StatusID   string `json:",omitempty"`
+}
+
+func displayName(u *tb.User) string {
+	if u.Username != "" {
+		return "@" + u.Username
+	}
+	return strings.TrimSpace(u.FirstName + " " + u.LastName)
+}
+
+func getTeamGroup(ctx context.Context, chatID int64) (*teamGroup, error) {
+	g := &teamGroup{}
+	if err := recordStore.GetRecord(ctx, teamGroupKind, chatKey(chatID), g); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+// isChatAdmin reports whether u administers chat.
+func isChatAdmin(chat *tb.Chat, u *tb.User) bool {
+	member, err := bot.ChatMemberOf(chat, u)
+	return err == nil && (member.Role == tb.Administrator || member.Role == tb.Creator)
+}
+
+// canApprove reports whether u may decide on drafts in g.
+func (g *teamGroup) canApprove(chat *tb.Chat, u *tb.User) bool {
+	if len(g.Approvers) > 0 {
+		for _, id := range g.Approvers {
+			if id == u.ID {
+				return true
+			}
+		}
+		return false
+	}
+	return isChatAdmin(chat, u)
+}
+
+// handleTeam sets up a group for a shared account:
+//
+//	/team link|unlink|log
+//	/team approvers add|remove (in reply to a member's message)
+//	/team approvers admins
+func handleTeam(m *tb.Message) {
+	ctx := context.Background()
+	if m.Private() {
+		bot.Send(m.Sender, "Use /team in the group that shares the account.")
+		return
+	}
+	args := strings.Fields(m.Payload)
+	g, err := getTeamGroup(ctx, m.Chat.ID)
+	if err != nil && err != errNoRecord {
+		log.Println("get team group error ", err)
+		return
+	}
+	if len(args) == 0 {
+		bot.Send(m.Chat, teamStatus(g))
+		return
+	}
+	if args[0] == "log" {
+		sendTeamLog(ctx, m.Chat)
+		return
+	}
+	if !isChatAdmin(m.Chat, m.Sender) {
+		bot.Send(m.Chat, "Only group administrators can change the shared account.")
+		return
+	}
+	if args[0] == "link" {
+		info, err := activeAccount(ctx, m.Sender.ID)
+		if err != nil {
+			bot.Send(m.Chat, "Link a Fanfou account with the bot in private first.")
+			return
+		}
+		if g == nil {
+			g = &teamGroup{ChatID: m.Chat.ID}
+		}
+		g.TelegramID, g.Account = m.Sender.ID, info.FanfouID
+	} else if g == nil {
+		bot.Send(m.Chat, "No account is shared here, use /team link first.")
+		return
+	}
+
+	switch {
+	case args[0] == "link":
+	case args[0] == "unlink":
+		if err := recordStore.DeleteRecord(ctx, teamGroupKind, chatKey(m.Chat.ID)); err != nil {
+			log.Println("delete team group error ", err)
+			return
+		}
+		bot.Send(m.Chat, "This group no longer shares "+g.Account+".")
+		return
+	case args[0] == "approvers" && len(args) > 1 && args[1] == "admins":
+		g.Approvers = nil
+	case args[0] == "approvers" && len(args) > 1 && m.ReplyTo != nil && m.ReplyTo.Sender != nil:
+		id := m.ReplyTo.Sender.ID
+		switch args[1] {
+		case "add":
+			g.Approvers = append(removeApprover(g.Approvers, id), id)
+		case "remove":
+			g.Approvers = removeApprover(g.Approvers, id)
+		}
+	default:
+		bot.Send(m.Chat, "Usage: /team link|unlink|log, /team approvers admins, or reply to a member with /team approvers add|remove.")
+		return
+	}
+	if err := recordStore.PutRecord(ctx, teamGroupKind, chatKey(m.Chat.ID), g); err != nil {
+		log.Println("save team group error ", err)
+		return
+	}
+	bot.Send(m.Chat, teamStatus(g))
+}
+
+func removeApprover(ids []int, id int) []int {
+	var out []int
+	for _, v := range ids {
+		if v != id {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+func teamStatus(g *teamGroup) string {
+	if g == nil {
+		return "No account is shared here. A group administrator can share theirs with /team link."
+	}
+	approvers := "group administrators"
+	if len(g.Approvers) > 0 {
+		var names []string
+		for _, id := range g.Approvers {
+			names = append(names, strconv.Itoa(id))
+		}
+		approvers = "users " + strings.Join(names, ", ")
+	}
+	return fmt.Sprintf("This group shares %s. Propose posts with /propose <text>; %s approve them.", g.Account, approvers)
+}
+
+// handlePropose posts a draft to the group for approval.
+func handlePropose(m *tb.Message) {
+	ctx := context.Background()
+	if m.Private() {
+		bot.Send(m.Sender, "Use /propose in a group that shares an account.")
+		return
+	}
+	g, err := getTeamGroup(ctx, m.Chat.ID)
+	if err != nil {
+		bot.Send(m.Chat, "No account is shared here. A group administrator can share theirs with /team link.")
+		return
+	}
+	text := commandText(m)
+	if text == "" {
+		bot.Send(m.Chat, "Usage: /propose <text>")
+		return
+	}
+	d := &teamDraft{Text: text, AuthorID: m.Sender.ID, AuthorName: displayName(m.Sender)}
+	msgID := strconv.Itoa(m.ID)
+	if err := recordStore.PutRecord(ctx, teamDraftKind, chatKey(m.Chat.ID)+":"+msgID, d); err != nil {
+		log.Println("save team draft error ", err)
+		return
+	}
+	approve, reject := teamApproveBtn, teamRejectBtn
+	approve.Data, reject.Data = msgID, msgID
+	bot.Reply(m, fmt.Sprintf("📝 Draft for %s by %s:\n\n%s", g.Account, html.EscapeString(d.AuthorName), html.EscapeString(text)),
+		&tb.ReplyMarkup{InlineKeyboard: [][]tb.InlineButton{{approve, reject}}}, tb.ModeHTML)
+}
+
+// teamDecision checks that c comes from an approver and takes the draft
+// it decides on.
+func teamDecision(ctx context.Context, c *tb.Callback) (*teamGroup, *teamDraft, bool) {
+	g, err := getTeamGroup(ctx, c.Message.Chat.ID)
+	if err != nil {
+		bot.Respond(c, &tb.CallbackResponse{Text: "No account is shared here any more."})
+		return nil, nil, false
+	}
+	if !g.canApprove(c.Message.Chat, c.Sender) {
+		bot.Respond(c, &tb.CallbackResponse{Text: "You are not an approver.", ShowAlert: true})
+		return nil, nil, false
+	}
+	d := &teamDraft{}
+	if err := recordStore.TakeRecord(ctx, teamDraftKind, chatKey(c.Message.Chat.ID)+":"+c.Data, d); err != nil {
+		bot.Respond(c, &tb.CallbackResponse{Text: "This draft was already decided."})
+		return nil, nil, false
+	}
+	return g, d, true
+}
+
+func handleTeamApprove(c *tb.Callback) {
+	ctx := context.Background()
+	g, d, ok := teamDecision(ctx, c)
+	if !ok {
+		return
+	}
+	restore := func() {
+		recordStore.PutRecord(ctx, teamDraftKind, chatKey(c.Message.Chat.ID)+":"+c.Data, d)
+	}
+	info, err := tokenStore.Get(ctx, g.TelegramID, g.Account)
+	if err != nil {
+		restore()
+		bot.Respond(c, &tb.CallbackResponse{Text: g.Account + " is no longer linked.", ShowAlert: true})
+		return
+	}
+	statuses, err := postThread(info, postedStatus{}, splitStatus(d.Text, statusLimit, true))
+	if len(statuses) == 0 {
+		restore()
+		respondFanfouError(c, err)
+		return
+	}
+	bot.Respond(c, &tb.CallbackResponse{Text: "Posted"})
+	addTeamAudit(ctx, c.Message.Chat.ID, &teamAuditEntry{
+		Action: "approved", AuthorID: d.AuthorID, AuthorName: d.AuthorName, DeciderID: c.Sender.ID, Decider: displayName(c.Sender),
+		Text: d.Text, StatusID: statuses[0].ID,
+	})
+	bot.Edit(c.Message, fmt.Sprintf("✅ Approved by %s, posted as %s: %s\n\n%s",
+		html.EscapeString(displayName(c.Sender)), g.Account, fanfou.StatusURL(statuses[0].ID), html.EscapeString(d.Text)), tb.ModeHTML)
+}
+
+func handleTeamReject(c *tb.Callback) {
+	ctx := context.Background()
+	_, d, ok := teamDecision(ctx, c)
+	if !ok {
+		return
+	}
+	bot.Respond(c, &tb.CallbackResponse{Text: "Rejected"})
+	addTeamAudit(ctx, c.Message.Chat.ID, &teamAuditEntry{
+		Action: "rejected", AuthorID: d.AuthorID, AuthorName: d.AuthorName, DeciderID: c.Sender.ID, Decider: displayName(c.Sender),
+		Text: d.Text,
+	})
+	bot.Edit(c.Message, fmt.Sprintf("❌ Rejected by %s\n\n%s", html.EscapeString(displayName(c.Sender)), html.EscapeString(d.Text)), tb.ModeHTML)
+}
+
+// addTeamAudit appends e to the audit trail of the group. Keys sort by
+// time.
+func addTeamAudit(ctx context.Context, chatID int64, e *teamAuditEntry) {
+	e.Time = time.Now()
+	key := fmt.Sprintf("%s:%020d", chatKey(chatID), e.Time.UnixNano())
+	if err := recordStore.PutRecord(ctx, teamAuditKind, key, e); err != nil {
+		log.Println("save team audit error ", err)
+	}
+}
+
+func sendTeamLog(ctx context.Context, chat *tb.Chat) {
+	keys, err := recordStore.RecordKeys(ctx, teamAuditKind, chatKey(chat.ID)+":")
+	if err != nil {
+		log.Println("list team audit error ", err)
+		return
+	}
+	if len(keys) == 0 {
+		bot.Send(chat, "No drafts were decided yet.")
+		return
+	}
+	sort.Strings(keys)
+	if len(keys) > teamAuditShown {
+		keys = keys[len(keys)-teamAuditShown:]
+	}
+	var b strings.Builder
+	for _, key := range keys {
+		e := &teamAuditEntry{}
+		if err := recordStore.GetRecord(ctx, teamAuditKind, key, e); err != nil {
+			continue
+		}
+		fmt.Fprintf(&b, "%s %s %s %s's draft", e.Time.Format("2006-01-02 15:04"),
+			html.EscapeString(e.Decider), e.Action, html.EscapeString(e.AuthorName))
+		if e.Text != "" {
+			b.WriteString(": " + html.EscapeString(truncateStatus(e.Text, 40)))
+		}
+		if e.StatusID != "" {
+			fmt.Fprintf(&b, " (<a href=\"%s\">status</a>)", fanfou.StatusURL(e.StatusID))
+		}
+		b.WriteString("\n")
+	}
+	bot.Send(chat, b.String(), tb.ModeHTML, tb.NoPreview)
+}
+
+// purgeTeamGroups stops the groups sharing accounts of telegramID and
+// deletes their drafts and audit trails.
+func purgeTeamGroups(ctx context.Context, telegramID int) (int, error) {
+	keys, err := recordStore.RecordKeys(ctx, teamGroupKind, "")
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, key := range keys {
+		g := &teamGroup{}
+		if err := recordStore.GetRecord(ctx, teamGroupKind, key, g); err != nil || g.TelegramID != telegramID {
+			continue
+		}
+		for _, kind := range []string{teamDraftKind, teamAuditKind} {
+			records, err := recordStore.RecordKeys(ctx, kind, key+":")
+			if err != nil {
+				return n, err
+			}
+			for _, r := range records {
+				if err := recordStore.DeleteRecord(ctx, kind, r); err != nil {
+					return n, err
+				}
+			}
+		}
+		if err := recordStore.DeleteRecord(ctx, teamGroupKind, key); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// deletedUserName replaces the names of users who deleted their data in
+// the audit trails of other groups.
+const deletedUserName = "a deleted user"
+
+// purgeTeamContributions deletes the pending drafts telegramID proposed
+// in other groups and removes them from the audit trails there: entries
+// for their drafts lose the author and the text, entries they decided
+// lose the decider. Entries saved before authors were recorded can't be
+// matched.
+func purgeTeamContributions(ctx context.Context, telegramID int) (drafts, entries int, err error) {
+	keys, err := recordStore.RecordKeys(ctx, teamDraftKind, "")
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, key := range keys {
+		d := &teamDraft{}
+		if err := recordStore.GetRecord(ctx, teamDraftKind, key, d); err != nil || d.AuthorID != telegramID {
+			continue
+		}
+		if err := recordStore.DeleteRecord(ctx, teamDraftKind, key); err != nil {
+			return drafts, entries, err
+		}
+		drafts++
+	}
+
+	keys, err = recordStore.RecordKeys(ctx, teamAuditKind, "")
+	if err != nil {
+		return drafts, entries, err
+	}
+	for _, key := range keys {
+		e := &teamAuditEntry{}
+		err := recordStore.UpdateRecord(ctx, teamAuditKind, key, e, func() error {
+			if e.AuthorID != telegramID && e.DeciderID != telegramID {
+				return errNoRecord
+			}
+			if e.AuthorID == telegramID {
+				e.AuthorID, e.AuthorName, e.Text = 0, deletedUserName, ""
+			}
+			if e.DeciderID == telegramID {
+				e.DeciderID, e.Decider = 0, deletedUserName
+			}
+			return nil
+		})
+		if err == errNoRecord {
+			continue
+		}
+		if err != nil {
+			return drafts, entries, err
+		}
+		entries++
+	}
+	return drafts, entries, nil
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pyzh/fanfou-telegram-bot/fanfou"
+	tb "gopkg.in/tucnak/telebot.v2"
+)
+
+const subscriptionKind = "subscriptions"
+
+const (
+	defaultPushInterval = 5 * time.Minute
+	minPushInterval     = time.Minute
+	maxPushInterval     = 24 * time.Hour
+)
+
+// subscription is a user's opt-in to home timeline push.
+type subscription struct {
+	// Account is the FanfouID whose home timeline is followed.
+	Account  string
+	Interval time.Duration
+	Paused   bool
+	// SinceID is the newest status already delivered.
+	SinceID  string
+	NextPoll time.Time
+	// Initialized is set once the first poll recorded where to start,
+	// even if the timeline was empty.
+	Initialized bool
+}
+
+func handleSubscribe(m *tb.Message) {
+	ctx := context.Background()
+	info, err := activeAccount(ctx, m.Sender.ID)
+	if err == errNoToken {
+		bot.Send(m.Sender, "Link a Fanfou account with /start first.")
+		return
+	}
+	if err != nil {
+		log.Println("get key error ", err)
+		return
+	}
+	sub := &subscription{Account: info.FanfouID, Interval: defaultPushInterval}
+	if err := recordStore.PutRecord(ctx, subscriptionKind, userKey(m.Sender.ID), sub); err != nil {
+		log.Println("save subscription error ", err)
+		return
+	}
+	bot.Send(m.Sender, fmt.Sprintf("Subscribed to the home timeline of %s, checked every %s. "+
+		"Use /interval <minutes>, /pause, /resume and /unsubscribe to change that.", info.FanfouID, sub.Interval))
+}
+
+func handleUnsubscribe(m *tb.Message) {
+	if err := recordStore.DeleteRecord(context.Background(), subscriptionKind, userKey(m.Sender.ID)); err != nil {
+		log.Println("delete subscription error ", err)
+		return
+	}
+	bot.Send(m.Sender, "Unsubscribed from the home timeline.")
+}
+
+func handlePause(m *tb.Message) {
+	updateSubscription(m, "Home timeline push paused.", func(sub *subscription) {
+		sub.Paused = true
+	})
+}
+
+func handleResume(m *tb.Message) {
+	updateSubscription(m, "Home timeline push resumed.", func(sub *subscription) {
+		sub.Paused = false
+		sub.NextPoll = time.Time{}
+	})
+}
+
+func handleInterval(m *tb.Message) {
+	minutes, err := strconv.Atoi(strings.TrimSpace(m.Payload))
+	interval := time.Duration(minutes) * time.Minute
+	if err != nil || interval < minPushInterval || interval > maxPushInterval {
+		bot.Send(m.Sender, fmt.Sprintf("Usage: /interval <minutes>, between %d and %d.",
+			int(minPushInterval.Minutes()), int(maxPushInterval.Minutes())))
+		return
+	}
+	updateSubscription(m, fmt.Sprintf("Checking every %s now.", interval), func(sub *subscription) {
+		sub.Interval = interval
+		sub.NextPoll = time.Now().Add(interval)
+	})
+}
+
+func updateSubscription(m *tb.Message, done string, fn func(sub *subscription)) {
+	ctx := context.Background()
+	key := userKey(m.Sender.ID)
+	sub := &subscription{}
+	err := recordStore.GetRecord(ctx, subscriptionKind, key, sub)
+	if err == errNoRecord {
+		bot.Send(m.Sender, "You are not subscribed. Send /subscribe first.")
+		return
+	}
+	if err != nil {
+		log.Println("get subscription error ", err)
+		return
+	}
+	fn(sub)
+	if err := recordStore.PutRecord(ctx, subscriptionKind, key, sub); err != nil {
+		log.Println("save subscription error ", err)
+		return
+	}
+	bot.Send(m.Sender, done)
+}
+
+// runTimelinePush delivers new home timeline statuses to subscribers
+// whose polling interval has passed, checking every tick.
+func runTimelinePush(ctx context.Context, tick time.Duration) {
+	for range time.Tick(tick) {
+		keys, err := recordStore.RecordKeys(ctx, subscriptionKind, "")
+		if err != nil {
+			log.Println("list subscriptions error ", err)
+			continue
+		}
+		for _, key := range keys {
+			telegramID, err := strconv.Atoi(key)
+			if err != nil {
+				continue
+			}
+			if err := pushTimeline(ctx, telegramID); err != nil {
+				log.Println("push timeline error ", err)
+			}
+		}
+	}
+}
+
+// pushTimeline polls the home timeline of telegramID if it is due. The
+// subscription is only changed through UpdateRecord, so commands run
+// while polling are kept, and a deleted subscription stays deleted.
+func pushTimeline(ctx context.Context, telegramID int) error {
+	key := userKey(telegramID)
+	sub := &subscription{}
+	if err := recordStore.GetRecord(ctx, subscriptionKind, key, sub); err != nil {
+		return err
+	}
+	if sub.Paused || time.Now().Before(sub.NextPoll) {
+		return nil
+	}
+	err := updateSubscriptionRecord(ctx, key, sub.Account, func(cur *subscription) {
+		cur.NextPoll = time.Now().Add(cur.Interval)
+	})
+	if err == errNoRecord {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	user := &tb.User{ID: telegramID}
+	info, err := tokenStore.Get(ctx, telegramID, sub.Account)
+	if err == errNoToken {
+		bot.Send(user, fmt.Sprintf("Home timeline push is paused because %s is no longer linked. Send /subscribe to follow your active account.", sub.Account))
+		return ignoreNoRecord(updateSubscriptionRecord(ctx, key, sub.Account, func(cur *subscription) {
+			cur.Paused = true
+		}))
+	}
+	if err != nil {
+		return err
+	}
+
+	statuses, err := clientFor(info).HomeTimeline(&fanfou.TimelineParams{SinceID: sub.SinceID, Count: 20})
+	if err != nil {
+		return err
+	}
+	// On the first poll only the position is remembered, so nobody gets
+	// flooded with old statuses.
+	if sub.Initialized || sub.SinceID != "" {
+		for i := len(statuses) - 1; i >= 0; i-- {
+			if _, err := sendStatus(ctx, telegramID, sub.Account, &statuses[i]); err != nil {
+				log.Println("send status error ", err)
+			}
+		}
+	}
+	return ignoreNoRecord(updateSubscriptionRecord(ctx, key, sub.Account, func(cur *subscription) {
+		cur.Initialized = true
+		if len(statuses) > 0 {
+			cur.SinceID = statuses[0].ID
+		}
+	}))
+}
+
+// updateSubscriptionRecord applies fn to the stored subscription. It
+// returns errNoRecord if the subscription was deleted or now follows
+// another account than account.
+func updateSubscriptionRecord(ctx context.Context, key, account string, fn func(cur *subscription)) error {
+	cur := &subscription{}
+	return recordStore.UpdateRecord(ctx, subscriptionKind, key, cur, func() error {
+		if cur.Account != account {
+			return errNoRecord
+		}
+		fn(cur)
+		return nil
+	})
+}
+
+// ignoreNoRecord drops errNoRecord: a poller has nothing to save for a
+// record its user deleted.
+func ignoreNoRecord(err error) error {
+	if err == errNoRecord {
+		return nil
+	}
+	return err
+}
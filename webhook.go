@@ -0,0 +1,102 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+
+	tb "gopkg.in/tucnak/telebot.v2"
+)
+
+// webhookPoller is a tb.Poller fed by Telegram webhook requests, so the
+// bot needs no long-lived getUpdates connection. Mount it on the router
+// at Path().
+type webhookPoller struct {
+	// URL is the public base URL of the server, e.g. https://example.com.
+	URL string
+	// Secret is sent by Telegram in the X-Telegram-Bot-Api-Secret-Token
+	// header of every request.
+	Secret string
+
+	updates chan tb.Update
+}
+
+func newWebhookPoller(publicURL, secret string) *webhookPoller {
+	return &webhookPoller{URL: publicURL, Secret: secret, updates: make(chan tb.Update, 100)}
+}
+
+// Path is derived from the secret so it can't be guessed, but the secret
+// itself never shows up in access logs.
+func (p *webhookPoller) Path() string {
+	sum := sha256.Sum256([]byte(p.Secret))
+	return "/telegram/" + hex.EncodeToString(sum[:16])
+}
+
+func (p *webhookPoller) Poll(b *tb.Bot, dest chan tb.Update, stop chan struct{}) {
+	params := map[string]string{"url": p.URL + p.Path(), "secret_token": p.Secret}
+	if err := callBotAPI(b, "setWebhook", params); err != nil {
+		log.Println("set webhook error ", err)
+	}
+	for {
+		select {
+		case upd := <-p.updates:
+			dest <- upd
+		case <-stop:
+			if err := callBotAPI(b, "deleteWebhook", map[string]string{}); err != nil {
+				log.Println("delete webhook error ", err)
+			}
+			close(stop)
+			return
+		}
+	}
+}
+
+func (p *webhookPoller) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	token := r.Header.Get("X-Telegram-Bot-Api-Secret-Token")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(p.Secret)) != 1 {
+		http.Error(w, "forbidden", 403)
+		return
+	}
+	data, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	upd, err := decodeUpdate(data)
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	select {
+	case p.updates <- upd:
+	case <-r.Context().Done():
+		// Telegram retries updates that were not acknowledged.
+		http.Error(w, "timeout", 503)
+		return
+	}
+	w.Write([]byte("ok"))
+}
+
+// callBotAPI calls a Bot API method that answers with a plain ok flag.
+func callBotAPI(b *tb.Bot, method string, params map[string]string) error {
+	data, err := b.Raw(method, params)
+	if err != nil {
+		return err
+	}
+	var resp struct {
+		Ok          bool   `json:"ok"`
+		Description string `json:"description"`
+	}
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return err
+	}
+	if !resp.Ok {
+		return fmt.Errorf("telegram %s: %s", method, resp.Description)
+	}
+	return nil
+}